@@ -0,0 +1,171 @@
+package bench
+
+import (
+	"fmt"
+	"lockfree/bspsc"
+	"lockfree/cspsc"
+	"lockfree/dspsc"
+	"lockfree/mpmc"
+	"lockfree/mpsc"
+	"lockfree/queue"
+	"lockfree/sema_spsc"
+	"lockfree/spmc"
+	"lockfree/spsc"
+	"sync"
+	"testing"
+)
+
+// chanQueue adapts a buffered channel to queue.Queue.
+type chanQueue struct {
+	ch   chan interface{}
+	once sync.Once
+	done chan struct{}
+}
+
+func newChanQueue(size uint64) queue.Queue {
+	return &chanQueue{ch: make(chan interface{}, size), done: make(chan struct{})}
+}
+
+func (q *chanQueue) Put(item interface{}) error {
+	select {
+	case q.ch <- item:
+		return nil
+	case <-q.done:
+		return queue.ErrDisposed
+	}
+}
+
+func (q *chanQueue) Get() (interface{}, error) {
+	select {
+	case item := <-q.ch:
+		return item, nil
+	case <-q.done:
+		return nil, queue.ErrDisposed
+	}
+}
+
+func (q *chanQueue) Dispose() {
+	q.once.Do(func() { close(q.done) })
+}
+
+func (q *chanQueue) IsDisposed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *chanQueue) Cap() uint64 {
+	return uint64(cap(q.ch))
+}
+
+var impls = []struct {
+	name string
+	new  func(size uint64) queue.Queue
+	// skip is the reason the implementation can't run the workload, if any.
+	skip string
+}{
+	{name: "channel", new: newChanQueue},
+	{name: "mpmc", new: func(size uint64) queue.Queue { return mpmc.NewRingBuffer[interface{}](size) }},
+	{name: "mpsc", new: func(size uint64) queue.Queue { return mpsc.NewRingBuffer[interface{}](size) }},
+	{name: "spmc", new: func(size uint64) queue.Queue { return spmc.NewRingBuffer[interface{}](size) }},
+	{name: "spsc", new: func(size uint64) queue.Queue { return spsc.NewRingBuffer(size) }},
+	{name: "bspsc", new: func(size uint64) queue.Queue { return bspsc.NewRingBuffer(size) }},
+	{name: "cspsc", new: func(size uint64) queue.Queue { return cspsc.NewRingBuffer(size) }},
+	{name: "dspsc", new: func(size uint64) queue.Queue { return dspsc.NewRingBuffer(size) }},
+	{name: "sema_spsc", new: func(size uint64) queue.Queue { return sema_spsc.NewRingBuffer(size) }},
+}
+
+var sizes = []uint64{64, 1024, 8192}
+
+// benchmarkSPSC moves b.N items from one producer to one consumer and waits
+// for the consumer to receive them all.
+func benchmarkSPSC(b *testing.B, q queue.Queue) {
+	done := make(chan struct{})
+
+	b.ResetTimer()
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		q.Put(`a`)
+	}
+	<-done
+}
+
+func BenchmarkSPSC(b *testing.B) {
+	for _, size := range sizes {
+		for _, im := range impls {
+			b.Run(fmt.Sprintf("size=%d/%s", size, im.name), func(b *testing.B) {
+				if im.skip != "" {
+					b.Skip(im.skip)
+				}
+				q := im.new(size)
+				defer q.Dispose()
+				benchmarkSPSC(b, q)
+			})
+		}
+	}
+}
+
+// producers is the number of producers in BenchmarkMPSC.
+const producers = 4
+
+// mpscImpls are the implementations safe for more than one producer.
+var mpscImpls = []struct {
+	name string
+	new  func(size uint64) queue.Queue
+}{
+	{name: "channel", new: newChanQueue},
+	{name: "mpmc", new: func(size uint64) queue.Queue { return mpmc.NewRingBuffer[interface{}](size) }},
+	{name: "mpsc", new: func(size uint64) queue.Queue { return mpsc.NewRingBuffer[interface{}](size) }},
+}
+
+// benchmarkMPSC moves b.N items from several producers to one consumer and
+// waits for the consumer to receive them all.
+func benchmarkMPSC(b *testing.B, q queue.Queue) {
+	done := make(chan struct{})
+
+	b.ResetTimer()
+	go func() {
+		defer close(done)
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		n := b.N / producers
+		if p < b.N%producers {
+			n++
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				q.Put(`a`)
+			}
+		}(n)
+	}
+	wg.Wait()
+	<-done
+}
+
+func BenchmarkMPSC(b *testing.B) {
+	for _, size := range sizes {
+		for _, im := range mpscImpls {
+			b.Run(fmt.Sprintf("size=%d/%s", size, im.name), func(b *testing.B) {
+				q := im.new(size)
+				defer q.Dispose()
+				benchmarkMPSC(b, q)
+			})
+		}
+	}
+}
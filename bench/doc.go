@@ -0,0 +1,5 @@
+// Package bench compares every queue implementation in this module, and a
+// buffered channel, under identical workloads.  Run it with
+//
+//	go test -bench . ./bench
+package bench
@@ -0,0 +1,237 @@
+package broadcast
+
+import (
+	"lockfree/queue"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// minSize is 2 to match the other ring buffers in this module.
+const minSize = 2
+
+// roundUp takes a uint64 greater than 0 and rounds it up to the next
+// power of 2.
+func roundUp(v uint64) uint64 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+// RingBuffer is a single producer ring buffer whose items are seen by every
+// registered Consumer, disruptor-style.  Each consumer has a read cursor of
+// its own, and the producer only advances past the slowest of them, so a
+// slow consumer applies backpressure to the producer.  Items put while no
+// consumer is registered are seen by nobody.
+type RingBuffer struct {
+	_         [8]uint64
+	write     uint64 // Owned by producer, read by consumers.
+	_         [8]uint64
+	limit     uint64 // Owned by producer: write may not reach it.
+	_         [8]uint64
+	mask      uint64
+	disposed  uint64
+	_         [8]uint64
+	mu        sync.Mutex   // Serializes NewConsumer and Close.
+	consumers atomic.Value // []*Consumer, replaced on every change.
+	data      []interface{}
+}
+
+// Consumer is a read cursor over a RingBuffer.  A Consumer must only be used
+// by one goroutine at a time.
+type Consumer struct {
+	_     [8]uint64
+	read  uint64 // Owned by consumer, read by producer.
+	avail uint64 // Owned by consumer: cached write of the producer.
+	_     [8]uint64
+	rb    *RingBuffer
+}
+
+func (rb *RingBuffer) init(size uint64) {
+	size = roundUp(size)
+	rb.data = make([]interface{}, size)
+	rb.mask = size - 1 // so we don't have to do this with every put/get operation
+	rb.limit = size
+	rb.consumers.Store([]*Consumer(nil))
+}
+
+// NewRingBuffer will allocate, initialize, and return a ring buffer
+// with the specified size.
+func NewRingBuffer(size uint64) *RingBuffer {
+	rb := &RingBuffer{}
+	if size < minSize {
+		size = minSize
+	}
+	rb.init(size)
+	return rb
+}
+
+// NewConsumer registers and returns a new read cursor.  It sees every item
+// put after NewConsumer returns.  It is safe to call concurrently with the
+// producer and the other consumers.
+func (rb *RingBuffer) NewConsumer() *Consumer {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	c := &Consumer{rb: rb}
+	c.read = atomic.LoadUint64(&rb.write)
+	c.avail = c.read
+	old := rb.consumers.Load().([]*Consumer)
+	consumers := make([]*Consumer, len(old), len(old)+1)
+	copy(consumers, old)
+	rb.consumers.Store(append(consumers, c))
+
+	// The producer may have computed its limit from the old consumers and
+	// put more items since c.read was loaded.  That limit is at most the
+	// write seen now plus the size, so starting c here keeps it safe.
+	atomic.StoreUint64(&c.read, atomic.LoadUint64(&rb.write))
+	c.avail = c.read
+	return c
+}
+
+// Close unregisters the consumer, so it no longer holds the producer back.
+// The consumer must not be used after Close.
+func (c *Consumer) Close() {
+	rb := c.rb
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	old := rb.consumers.Load().([]*Consumer)
+	consumers := make([]*Consumer, 0, len(old))
+	for _, o := range old {
+		if o != c {
+			consumers = append(consumers, o)
+		}
+	}
+	rb.consumers.Store(consumers)
+}
+
+// Dispose will dispose of this queue and free any blocked threads
+// in the Put and/or Get methods.  Calling those methods on a disposed
+// queue will return an error.
+func (rb *RingBuffer) Dispose() {
+	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this queue has been
+// disposed.
+func (rb *RingBuffer) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *RingBuffer) Cap() uint64 {
+	return uint64(len(rb.data))
+}
+
+// Put adds the provided item to the queue.  If the slowest consumer is a
+// full buffer behind, this call will block until it catches up or Dispose is
+// called on the queue.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer) Put(item interface{}) error {
+	_, err := rb.put(item, false)
+	return err
+}
+
+// Offer adds the provided item to the queue if there is space.  If the
+// slowest consumer is a full buffer behind, this call will return false.  An
+// error will be returned if the queue is disposed.
+func (rb *RingBuffer) Offer(item interface{}) (bool, error) {
+	return rb.put(item, true)
+}
+
+func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
+	pos := rb.write
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return false, ErrDisposed
+		}
+
+		if int64(rb.limit-pos) > 0 {
+			break
+		}
+		rb.limit = rb.gate(pos)
+		if int64(rb.limit-pos) > 0 {
+			break
+		}
+
+		if offer {
+			return false, nil
+		}
+
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	rb.data[pos&rb.mask] = item
+	atomic.StoreUint64(&rb.write, pos+1) // cache coherence traffic
+	return true, nil
+}
+
+// gate returns the position the producer may not reach: a full buffer past
+// the slowest consumer, or past pos if there are none.
+func (rb *RingBuffer) gate(pos uint64) uint64 {
+	min := pos
+	for _, c := range rb.consumers.Load().([]*Consumer) {
+		if read := atomic.LoadUint64(&c.read); int64(read-min) < 0 {
+			min = read
+		}
+	}
+	return min + rb.mask + 1
+}
+
+// Get will return the next item for this consumer.  This call will block
+// if the consumer has seen every item.  This call will unblock when an item
+// is added to the queue or Dispose is called on the queue.  An error will be
+// returned if the queue is disposed.
+func (c *Consumer) Get() (interface{}, error) {
+	return c.Poll(0)
+}
+
+// Poll will return the next item for this consumer.  This call will block
+// if the consumer has seen every item.  This call will unblock when an item
+// is added to the queue, Dispose is called on the queue, or the timeout is
+// reached.  An error will be returned if the queue is disposed or a timeout
+// occurs.  A non-positive timeout will block indefinitely.
+func (c *Consumer) Poll(timeout time.Duration) (interface{}, error) {
+	var (
+		rb    = c.rb
+		pos   = c.read
+		start time.Time
+	)
+	if timeout > 0 {
+		start = time.Now()
+	}
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return nil, ErrDisposed
+		}
+
+		if int64(c.avail-pos) > 0 {
+			break
+		}
+		c.avail = atomic.LoadUint64(&rb.write)
+		if int64(c.avail-pos) > 0 {
+			break
+		}
+
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, ErrTimeout
+		}
+
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	item := rb.data[pos&rb.mask]
+	atomic.StoreUint64(&c.read, pos+1) // cache coherence traffic
+	return item, nil
+}
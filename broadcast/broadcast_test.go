@@ -0,0 +1,138 @@
+package broadcast
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEveryConsumerSeesEveryItem(t *testing.T) {
+	const (
+		consumers = 4
+		items     = 10000
+	)
+	q := NewRingBuffer(64)
+	cs := make([]*Consumer, consumers)
+	for i := range cs {
+		cs[i] = q.NewConsumer()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, consumers)
+	for _, c := range cs {
+		wg.Add(1)
+		go func(c *Consumer) {
+			defer wg.Done()
+			for i := 0; i < items; i++ {
+				got, err := c.Get()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if got != i {
+					t.Errorf("expected %d, got %v", i, got)
+					return
+				}
+			}
+		}(c)
+	}
+
+	for i := 0; i < items; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestSlowConsumerBackpressure(t *testing.T) {
+	q := NewRingBuffer(2)
+	fast, slow := q.NewConsumer(), q.NewConsumer()
+	for i := 0; i < 2; i++ {
+		if ok, err := q.Offer(i); !ok || err != nil {
+			t.Fatalf("expected offer to succeed, got %v, %v", ok, err)
+		}
+		fast.Get()
+	}
+	if ok, _ := q.Offer(2); ok {
+		t.Fatal("expected offer to fail while the slow consumer is behind")
+	}
+	if got, _ := slow.Get(); got != 0 {
+		t.Fatalf("expected 0, got %v", got)
+	}
+	if ok, _ := q.Offer(2); !ok {
+		t.Fatal("expected offer to succeed once the slow consumer caught up")
+	}
+
+	// A closed consumer no longer holds the producer back.
+	slow.Close()
+	fast.Get()
+	for i := 3; i < 5; i++ {
+		if ok, _ := q.Offer(i); !ok {
+			t.Fatalf("expected offer of %d to succeed after close", i)
+		}
+	}
+}
+
+func TestNewConsumerSeesLaterItems(t *testing.T) {
+	q := NewRingBuffer(4)
+	// Nobody holds the producer back, so these are never seen.
+	for i := 0; i < 10; i++ {
+		if ok, _ := q.Offer(i); !ok {
+			t.Fatalf("expected offer of %d to succeed without consumers", i)
+		}
+	}
+	c := q.NewConsumer()
+	if _, err := c.Poll(time.Millisecond); err != ErrTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	q.Put(10)
+	if got, _ := c.Get(); got != 10 {
+		t.Fatalf("expected 10, got %v", got)
+	}
+}
+
+func TestDispose(t *testing.T) {
+	q := NewRingBuffer(2)
+	c := q.NewConsumer()
+	done := make(chan error)
+	go func() {
+		_, err := c.Get()
+		done <- err
+	}()
+	q.Dispose()
+	if err := <-done; err != ErrDisposed {
+		t.Fatalf("expected %v, got %v", ErrDisposed, err)
+	}
+	if err := q.Put(0); err != ErrDisposed {
+		t.Fatalf("expected %v, got %v", ErrDisposed, err)
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	const consumers = 4
+	q := NewRingBuffer(8192)
+	defer q.Dispose()
+
+	var wg sync.WaitGroup
+	for i := 0; i < consumers; i++ {
+		c := q.NewConsumer()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < b.N; n++ {
+				c.Get()
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Put(`a`)
+	}
+	wg.Wait()
+}
@@ -2,13 +2,29 @@ package bspsc
 
 import (
 	"errors"
+	"lockfree/queue"
 	"runtime"
 	"sync/atomic"
 	"time"
 )
 
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// ErrMaxBatchOutOfRange is returned by SetMaxBatch for a batch of 0 or
+// larger than the capacity.
+var ErrMaxBatchOutOfRange = errors.New(`queue: max batch out of range`)
+
 const defaultMaxBatch uint64 = (1 << 8) - 1
 
+// pullSpins is how many spins a Get on a seemingly empty queue, or a Put on
+// a seemingly full one, waits before publishing the other side's cursor on
+// its behalf.
+const pullSpins = 16
+
 // roundUp takes a uint64 greater than 0 and rounds it up to the next
 // power of 2.
 func roundUp(v uint64) uint64 {
@@ -30,26 +46,120 @@ type node struct {
 
 type nodes []node
 
-// RingBuffer is a SPSC lockfree queue. This implementation has a bug where
-// during low traffic, write + read might never get published so consumer
-// will not be able to read even when the queue has items.
+// RingBuffer is a SPSC lockfree queue publishing its cursors in batches.
+// During low traffic a batch may never fill up, so a consumer finding the
+// queue empty publishes the producer's cursor itself after a few spins, and
+// a producer finding it full does the same with the consumer's cursor.
 //
-// Possible fix: producer/consumer flush every so often during low traffic?
+// Flush publishes the producer's cursor right away, and WithIdleFlush
+// publishes both cursors every so often.
 type RingBuffer struct {
 	_          [8]uint64
-	writeCache uint64 // Not shared.
+	writeCache uint64 // Owned by producer, shared with the idle flusher.
 	_          [8]uint64
 	write      uint64 // Shared, owned by producer.
 	_          [8]uint64
 	read       uint64 // Shared, owned by consumer.
 	_          [8]uint64
-	readCache  uint64 // Not shared.
+	readCache  uint64 // Owned by consumer, shared with the idle flusher.
 	_          [8]uint64
 	mask       uint64
 	disposed   uint64
-	maxbatch   uint64
+	maxbatch   uint64 // Shared, set by producer.
 	_          [8]uint64
 	nodes      nodes
+
+	// flushDone stops the idle flusher. Nil unless the queue was created
+	// with WithIdleFlush.
+	flushDone chan struct{}
+
+	// starvation is how long the consumer may wait while unpublished items
+	// exist before StarvationDetected reports it, and blockedSince is when
+	// the consumer started waiting in Get, 0 when it is not. Only set when
+	// the queue was created with WithStarvationThreshold.
+	starvation   time.Duration
+	blockedSince int64 // Shared, owned by consumer.
+}
+
+// Option configures a RingBuffer at construction time.
+type Option func(rb *RingBuffer)
+
+// WithIdleFlush starts a goroutine that publishes the producer's and the
+// consumer's cached cursors every interval, so items trickling in below the
+// batch size reach the consumer within interval instead of stalling.  The
+// goroutine exits when the queue is disposed.
+func WithIdleFlush(interval time.Duration) Option {
+	return func(rb *RingBuffer) {
+		rb.flushDone = make(chan struct{})
+		go rb.flushIdle(interval)
+	}
+}
+
+// WithStarvationThreshold makes the consumer record when it starts waiting
+// in Get, so that StarvationDetected can tell it has been waiting for longer
+// than threshold while the producer holds unpublished items.
+func WithStarvationThreshold(threshold time.Duration) Option {
+	return func(rb *RingBuffer) {
+		rb.starvation = threshold
+	}
+}
+
+// StarvationDetected reports whether the consumer has been waiting in Get
+// for longer than the threshold set with WithStarvationThreshold while the
+// producer holds items it has not published, i.e. whether the consumer is
+// stalled on items it can't see.  It may be called from any goroutine, e.g.
+// a monitor.  It always returns false without WithStarvationThreshold.
+func (rb *RingBuffer) StarvationDetected() bool {
+	since := atomic.LoadInt64(&rb.blockedSince)
+	if since == 0 || time.Since(time.Unix(0, since)) < rb.starvation {
+		return false
+	}
+	return int64(atomic.LoadUint64(&rb.writeCache)-atomic.LoadUint64(&rb.write)) > 0
+}
+
+func (rb *RingBuffer) flushIdle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			publish(&rb.write, atomic.LoadUint64(&rb.writeCache))
+			publish(&rb.read, atomic.LoadUint64(&rb.readCache))
+		case <-rb.flushDone:
+			return
+		}
+	}
+}
+
+// pull publishes cursor on behalf of its owner, up to the owner's cached
+// value, and reports whether that moved it.  Caches are only stored after
+// the nodes they cover, so the other side can publish them safely.
+func pull(cursor, cache *uint64) bool {
+	v := atomic.LoadUint64(cache)
+	if int64(v-atomic.LoadUint64(cursor)) <= 0 {
+		return false
+	}
+	publish(cursor, v)
+	return true
+}
+
+// Flush publishes every item put so far, for the producer to call after the
+// last item of a burst, so that the consumer gets them without waiting for
+// a batch to fill up or for the consumer to pull them.
+func (rb *RingBuffer) Flush() {
+	publish(&rb.write, rb.writeCache) // cache coherence traffic.
+}
+
+// publish advances cursor to v, unless it is already past it.  Cursors are
+// published by their owner and by the idle flusher, which must not move
+// them backwards.
+func publish(cursor *uint64, v uint64) {
+	for {
+		old := atomic.LoadUint64(cursor)
+		if int64(v-old) <= 0 || atomic.CompareAndSwapUint64(cursor, old, v) {
+			return
+		}
+	}
 }
 
 func (rb *RingBuffer) init(size uint64) {
@@ -64,9 +174,12 @@ func (rb *RingBuffer) init(size uint64) {
 
 // NewRingBuffer will allocate, initialize, and return a ring buffer
 // with the specified size.
-func NewRingBuffer(size uint64) *RingBuffer {
+func NewRingBuffer(size uint64, opts ...Option) *RingBuffer {
 	rb := &RingBuffer{}
 	rb.init(size)
+	for _, opt := range opts {
+		opt(rb)
+	}
 	return rb
 }
 
@@ -74,7 +187,9 @@ func NewRingBuffer(size uint64) *RingBuffer {
 // in the Put and/or Get methods.  Calling those methods on a disposed
 // queue will return an error.
 func (rb *RingBuffer) Dispose() {
-	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+	if atomic.CompareAndSwapUint64(&rb.disposed, 0, 1) && rb.flushDone != nil {
+		close(rb.flushDone)
+	}
 }
 
 // IsDisposed will return a bool indicating if this queue has been
@@ -88,6 +203,24 @@ func (rb *RingBuffer) Cap() uint64 {
 	return uint64(len(rb.nodes))
 }
 
+// MaxBatch returns the number of items after which the producer and the
+// consumer publish their cursors.
+func (rb *RingBuffer) MaxBatch() uint64 {
+	return atomic.LoadUint64(&rb.maxbatch)
+}
+
+// SetMaxBatch changes the number of items after which the producer and the
+// consumer publish their cursors, which can be done at any time: a smaller
+// batch lowers latency, a larger one raises throughput.
+// ErrMaxBatchOutOfRange is returned if n is 0 or larger than the capacity.
+func (rb *RingBuffer) SetMaxBatch(n uint64) error {
+	if n == 0 || n > rb.Cap() {
+		return ErrMaxBatchOutOfRange
+	}
+	atomic.StoreUint64(&rb.maxbatch, n)
+	return nil
+}
+
 // Get will return the next item in the queue.  This call will block
 // if the queue is empty.  This call will unblock when an item is added
 // to the queue or Dispose is called on the queue.  An error will be returned
@@ -108,35 +241,106 @@ func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
 	}
 
 	rd := rb.readCache
+	spins := 0
+	blocked := false
+	if rb.starvation > 0 {
+		defer func() {
+			if blocked {
+				atomic.StoreInt64(&rb.blockedSince, 0)
+			}
+		}()
+	}
 	for {
 		if atomic.LoadUint64(&rb.disposed) > 0 {
-			return nil, errors.New(`queue: closed`)
+			return nil, ErrDisposed
 		}
 		wr := atomic.LoadUint64(&rb.write)
 		// Not emtpy.
 		if rd != wr {
 			break
 		}
+		if rb.starvation > 0 && !blocked {
+			blocked = true
+			atomic.StoreInt64(&rb.blockedSince, time.Now().UnixNano())
+		}
 		// Publish latest read.
-		if rd > rb.read {
-			atomic.StoreUint64(&rb.read, rd) // cache coherence traffic.
+		if int64(rd-atomic.LoadUint64(&rb.read)) > 0 {
+			publish(&rb.read, rd) // cache coherence traffic.
+		}
+		// The producer may hold a batch it has not published.
+		spins++
+		if spins%pullSpins == 0 && pull(&rb.write, &rb.writeCache) {
+			continue
 		}
 		if timeout > 0 && time.Since(start) >= timeout {
-			return nil, errors.New(`queue: poll timed out`)
+			return nil, ErrTimeout
 		}
 		runtime.Gosched() // free up the cpu before the next iteration
 	}
 	n := &rb.nodes[rd&rb.mask]
 	data := n.data
 	n.data = nil
-	rb.readCache++
+	rd++
+	atomic.StoreUint64(&rb.readCache, rd)
 	// Publish batch.
-	if rb.readCache-rb.read >= rb.maxbatch {
-		atomic.StoreUint64(&rb.read, rb.readCache) // cache coherence traffic.
+	if rd-atomic.LoadUint64(&rb.read) >= atomic.LoadUint64(&rb.maxbatch) {
+		publish(&rb.read, rd) // cache coherence traffic.
 	}
 	return data, nil
 }
 
+// DrainToChan sends the queue's items to out in batches of up to batchMax,
+// each a new slice, so a channel based consumer pays for one channel send per
+// batch rather than per item.  A batch is sent as soon as no more published
+// items are ready, so it can be partial.  It runs until the queue is
+// disposed, then sends the items left in the queue, published or not, and
+// closes out.  A batchMax below 1 is taken as 1.  DrainToChan takes the
+// place of the single consumer.
+func (rb *RingBuffer) DrainToChan(out chan<- []interface{}, batchMax int) {
+	defer close(out)
+	if batchMax < 1 {
+		batchMax = 1
+	}
+	for {
+		first, err := rb.Get()
+		if err != nil {
+			rb.drainLeftovers(out, batchMax)
+			return
+		}
+		batch := append(make([]interface{}, 0, batchMax), first)
+
+		rd := rb.readCache
+		wr := atomic.LoadUint64(&rb.write)
+		for ; rd != wr && len(batch) < batchMax; rd++ {
+			n := &rb.nodes[rd&rb.mask]
+			batch = append(batch, n.data)
+			n.data = nil
+		}
+		atomic.StoreUint64(&rb.readCache, rd)
+		publish(&rb.read, rd) // cache coherence traffic.
+		out <- batch
+	}
+}
+
+// drainLeftovers sends the items left in a disposed queue to out in batches
+// of up to batchMax.  The producer's unpublished writes are included, as no
+// more will be published.
+func (rb *RingBuffer) drainLeftovers(out chan<- []interface{}, batchMax int) {
+	rd := rb.readCache
+	wr := atomic.LoadUint64(&rb.writeCache)
+	for rd != wr {
+		batch := make([]interface{}, 0, batchMax)
+		for ; rd != wr && len(batch) < batchMax; rd++ {
+			n := &rb.nodes[rd&rb.mask]
+			batch = append(batch, n.data)
+			n.data = nil
+		}
+		atomic.StoreUint64(&rb.readCache, rd)
+		publish(&rb.read, rd) // cache coherence traffic.
+		out <- batch
+	}
+}
+
 // Put adds the provided item to the queue.  If the queue is full, this
 // call will block until an item is added to the queue or Dispose is called
 // on the queue.  An error will be returned if the queue is disposed.
@@ -154,18 +358,25 @@ func (rb *RingBuffer) Offer(item interface{}) (bool, error) {
 
 func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
 	wr := rb.writeCache
+	spins := 0
 	for {
 		if atomic.LoadUint64(&rb.disposed) > 0 {
-			return false, errors.New(`queue: closed`)
+			return false, ErrDisposed
 		}
 		rd := atomic.LoadUint64(&rb.read)
 		// Not full.
-		if wr < rd+rb.Cap() {
+		if wr-rd < rb.Cap() {
 			break
 		}
 		// Publish latest write.
-		if wr > rb.write {
-			atomic.StoreUint64(&rb.write, wr) // cache coherence traffic.
+		if int64(wr-atomic.LoadUint64(&rb.write)) > 0 {
+			publish(&rb.write, wr) // cache coherence traffic.
+		}
+		// The consumer may hold reads it has not published: pull them
+		// before an Offer gives up, or every few spins of a Put.
+		spins++
+		if (offer || spins%pullSpins == 0) && pull(&rb.read, &rb.readCache) {
+			continue
 		}
 		if offer {
 			return false, nil
@@ -174,11 +385,11 @@ func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
 	}
 	n := &rb.nodes[wr&rb.mask]
 	n.data = item
-	rb.writeCache++
-	atomic.StoreUint64(&rb.writeCache, rb.writeCache)
+	wr++
+	atomic.StoreUint64(&rb.writeCache, wr)
 	// Publish batch.
-	if rb.writeCache-rb.write >= rb.maxbatch {
-		atomic.StoreUint64(&rb.write, rb.writeCache) // cache coherence traffic.
+	if wr-atomic.LoadUint64(&rb.write) >= atomic.LoadUint64(&rb.maxbatch) {
+		publish(&rb.write, wr) // cache coherence traffic.
 	}
 	return true, nil
 }
@@ -1,7 +1,12 @@
 package bspsc
 
 import (
+	"lockfree/internal/queuetest"
+	"math"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func BenchmarkChannel(b *testing.B) {
@@ -33,3 +38,282 @@ func BenchmarkBSPSC(b *testing.B) {
 		q.Put(`a`)
 	}
 }
+
+func TestIdleFlush(t *testing.T) {
+	const interval = 5 * time.Millisecond
+	q := NewRingBuffer(1024, WithIdleFlush(interval))
+	defer q.Dispose()
+
+	// A producer trickling far fewer items than the batch size.
+	for i := 0; i < 3; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+		got, err := q.Poll(100 * interval)
+		if err != nil {
+			t.Fatalf("item %d not delivered: %v", i, err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+}
+
+// TestNodeSize documents the size of a node on 64-bit platforms: a uint64 and
+// an interface{}, so nodes don't line up with 64 byte cache lines and some
+// straddle two.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node{}); size != 24 {
+		t.Fatalf("expected a 24 byte node, got %d", size)
+	}
+}
+
+func TestDrainToChan(t *testing.T) {
+	const count = 1000
+	q := NewRingBuffer(64, WithIdleFlush(time.Millisecond))
+	out := make(chan []interface{}, 4)
+	go q.DrainToChan(out, 16)
+
+	go func() {
+		for i := 0; i < count; i++ {
+			q.Put(i)
+		}
+	}()
+
+	var next int
+	for next < count {
+		batch := <-out
+		if len(batch) == 0 || len(batch) > 16 {
+			t.Fatalf("expected 1 to 16 items per batch, got %d", len(batch))
+		}
+		for _, item := range batch {
+			if item != next {
+				t.Fatalf("expected %d, got %v", next, item)
+			}
+			next++
+		}
+	}
+	q.Dispose()
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatal("expected no more batches")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected out to be closed once the queue is disposed")
+	}
+}
+
+func TestSetMaxBatch(t *testing.T) {
+	q := NewRingBuffer(16)
+	if err := q.SetMaxBatch(0); err != ErrMaxBatchOutOfRange {
+		t.Fatal("expected a max batch of 0 to be rejected")
+	}
+	if err := q.SetMaxBatch(17); err != ErrMaxBatchOutOfRange {
+		t.Fatal("expected a max batch above the capacity to be rejected")
+	}
+
+	if err := q.SetMaxBatch(4); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		q.Put(i)
+	}
+	if wr := atomic.LoadUint64(&q.write); wr != 0 {
+		t.Fatalf("expected 3 items to stay unpublished with a batch of 4, got %d published", wr)
+	}
+	q.Put(3)
+	if wr := atomic.LoadUint64(&q.write); wr != 4 {
+		t.Fatalf("expected a batch of 4 published, got %d", wr)
+	}
+	for i := 0; i < 4; i++ {
+		if got, err := q.Poll(time.Second); got != i {
+			t.Fatalf("expected %d, got %v, %v", i, got, err)
+		}
+	}
+
+	// Shrinking the batch to 1 publishes every item right away.
+	if err := q.SetMaxBatch(1); err != nil {
+		t.Fatal(err)
+	}
+	if q.MaxBatch() != 1 {
+		t.Fatalf("expected a max batch of 1, got %d", q.MaxBatch())
+	}
+	q.Put(4)
+	if got, err := q.Poll(time.Second); got != 4 {
+		t.Fatalf("expected 4, got %v, %v", got, err)
+	}
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer(64, WithIdleFlush(time.Millisecond))
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	q.Dispose()
+	if rd := atomic.LoadUint64(&q.read); rd >= uint64(ops) {
+		t.Fatalf("expected the read sequence to have wrapped around, got %d", rd)
+	}
+}
+
+func TestConservation(t *testing.T) {
+	q := NewRingBuffer(512, WithIdleFlush(time.Millisecond))
+	defer q.Dispose()
+	queuetest.Conservation(t, q, q.counters)
+}
+
+func TestStarvationDetected(t *testing.T) {
+	q := NewRingBuffer(64, WithStarvationThreshold(5*time.Millisecond))
+	defer q.Dispose()
+
+	// A trickle of items, fewer than a batch, stays unpublished until the
+	// consumer pulls it.
+	for i := 0; i < 3; i++ {
+		q.Put(i)
+	}
+	if q.StarvationDetected() {
+		t.Fatal("expected no starvation detected while the consumer is not waiting")
+	}
+	// As if the consumer had been waiting for long without pulling.
+	atomic.StoreInt64(&q.blockedSince, time.Now().Add(-time.Second).UnixNano())
+	if !q.StarvationDetected() {
+		t.Fatal("expected starvation detected while unpublished items exist")
+	}
+	if got, err := q.Poll(time.Second); got != 0 || err != nil {
+		t.Fatalf("expected 0, got %v, %v", got, err)
+	}
+	if q.StarvationDetected() {
+		t.Fatal("expected no starvation detected once the consumer got an item")
+	}
+
+	// Nothing is unpublished after a Flush.
+	q.Put(3)
+	q.Flush()
+	atomic.StoreInt64(&q.blockedSince, time.Now().Add(-time.Second).UnixNano())
+	if q.StarvationDetected() {
+		t.Fatal("expected no starvation detected after a flush")
+	}
+	atomic.StoreInt64(&q.blockedSince, 0)
+
+	if q := NewRingBuffer(64); q.StarvationDetected() {
+		t.Fatal("expected no starvation detected without a threshold")
+	}
+}
+
+// TestLowTraffic checks that a trickle of items, far fewer than a batch,
+// reaches the consumer without any flushing.
+func TestLowTraffic(t *testing.T) {
+	q := NewRingBuffer(1024)
+	defer q.Dispose()
+	for i := 0; i < 3; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if got, err := q.Poll(time.Second); got != i || err != nil {
+			t.Fatalf("expected %d, got %v, %v", i, got, err)
+		}
+	}
+
+	// Reads the consumer has not published are pulled by a producer that
+	// finds the queue full.
+	q = NewRingBuffer(4)
+	defer q.Dispose()
+	for i := 0; i < 4; i++ {
+		q.Put(i)
+	}
+	q.Get()
+	if ok, err := q.Offer(4); !ok || err != nil {
+		t.Fatalf("expected Offer to find room, got %v, %v", ok, err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	q := NewRingBuffer(1024)
+	defer q.Dispose()
+	q.Put(0)
+	if wr := atomic.LoadUint64(&q.write); wr != 0 {
+		t.Fatalf("expected the item unpublished, got %d published", wr)
+	}
+	q.Flush()
+	if wr := atomic.LoadUint64(&q.write); wr != 1 {
+		t.Fatalf("expected the item published, got %d published", wr)
+	}
+}
+
+func TestDrainToChanAfterDispose(t *testing.T) {
+	q := NewRingBuffer(64)
+	for i := 0; i < 40; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Dispose()
+
+	out := make(chan []interface{}, 4)
+	go q.DrainToChan(out, 16)
+	var next int
+	for batch := range out {
+		if len(batch) == 0 || len(batch) > 16 {
+			t.Fatalf("expected 1 to 16 items per batch, got %d", len(batch))
+		}
+		for _, item := range batch {
+			if item != next {
+				t.Fatalf("expected %d, got %v", next, item)
+			}
+			next++
+		}
+	}
+	if next != 40 {
+		t.Fatalf("expected the 40 items left at dispose, got %d", next)
+	}
+}
+
+func TestDrainToChanBatchMax(t *testing.T) {
+	for _, batchMax := range []int{0, -1} {
+		q := NewRingBuffer(8)
+		for i := 0; i < 3; i++ {
+			q.Put(i)
+		}
+		q.Dispose()
+
+		out := make(chan []interface{}, 4)
+		go q.DrainToChan(out, batchMax)
+		var next int
+		for batch := range out {
+			if len(batch) != 1 {
+				t.Fatalf("batchMax %d: expected single item batches, got %v", batchMax, batch)
+			}
+			if batch[0] != next {
+				t.Fatalf("batchMax %d: expected %d, got %v", batchMax, next, batch[0])
+			}
+			next++
+		}
+		if next != 3 {
+			t.Fatalf("batchMax %d: expected 3 items, got %d", batchMax, next)
+		}
+	}
+}
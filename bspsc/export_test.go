@@ -0,0 +1,18 @@
+package bspsc
+
+import "sync/atomic"
+
+// fastForward moves the cursors of an empty queue to seq, as if seq items
+// had gone through it, to test sequences wrapping around.
+func (rb *RingBuffer) fastForward(seq uint64) {
+	for _, cursor := range []*uint64{&rb.write, &rb.writeCache, &rb.read, &rb.readCache} {
+		atomic.StoreUint64(cursor, seq)
+	}
+}
+
+// counters returns the number of items put, got, and in the queue, from the
+// cached cursors as the published ones lag behind.
+func (rb *RingBuffer) counters() (put, got, length uint64) {
+	wr, rd := atomic.LoadUint64(&rb.writeCache), atomic.LoadUint64(&rb.readCache)
+	return wr, rd, wr - rd
+}
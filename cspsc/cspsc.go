@@ -1,12 +1,18 @@
 package cspsc
 
 import (
-	"errors"
+	"lockfree/queue"
 	"runtime"
 	"sync/atomic"
 	"time"
 )
 
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
 const defaultMaxBatch uint64 = (1 << 8) - 1
 
 // roundUp takes a uint64 greater than 0 and rounds it up to the next
@@ -108,7 +114,7 @@ func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
 	rd := atomic.LoadUint64(&rb.read)
 	for {
 		if atomic.LoadUint64(&rb.disposed) > 0 {
-			return nil, errors.New(`queue: closed`)
+			return nil, ErrDisposed
 		}
 		// Try write cache.
 		if rd != rb.writeCache {
@@ -120,7 +126,7 @@ func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
 			break
 		}
 		if timeout > 0 && time.Since(start) >= timeout {
-			return nil, errors.New(`queue: poll timed out`)
+			return nil, ErrTimeout
 		}
 		runtime.Gosched() // free up the cpu before the next iteration
 	}
@@ -150,15 +156,15 @@ func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
 	wr := atomic.LoadUint64(&rb.write)
 	for {
 		if atomic.LoadUint64(&rb.disposed) > 0 {
-			return false, errors.New(`queue: closed`)
+			return false, ErrDisposed
 		}
 		// Try read cache.
-		if wr < rb.readCache+rb.Cap() {
+		if wr-rb.readCache < rb.Cap() {
 			break
 		}
 		// Try latest read.
 		rb.readCache = atomic.LoadUint64(&rb.read)
-		if wr < rb.readCache+rb.Cap() {
+		if wr-rb.readCache < rb.Cap() {
 			break
 		}
 		if offer {
@@ -1,7 +1,9 @@
 package cspsc
 
 import (
+	"math"
 	"testing"
+	"unsafe"
 )
 
 func BenchmarkChannel(b *testing.B) {
@@ -33,3 +35,44 @@ func BenchmarkCSPSC(b *testing.B) {
 		q.Put(`a`)
 	}
 }
+
+// TestNodeSize documents the size of a node on 64-bit platforms: a uint64 and
+// an interface{}, so nodes don't line up with 64 byte cache lines and some
+// straddle two.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node{}); size != 24 {
+		t.Fatalf("expected a 24 byte node, got %d", size)
+	}
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer(64)
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.read >= uint64(ops) {
+		t.Fatalf("expected the read sequence to have wrapped around, got %d", q.read)
+	}
+}
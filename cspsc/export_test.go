@@ -0,0 +1,7 @@
+package cspsc
+
+// fastForward moves the cursors of an empty queue to seq, as if seq items
+// had gone through it, to test sequences wrapping around.
+func (rb *RingBuffer) fastForward(seq uint64) {
+	rb.write, rb.writeCache, rb.read, rb.readCache = seq, seq, seq, seq
+}
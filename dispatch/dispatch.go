@@ -0,0 +1,113 @@
+// Package dispatch spreads the items of an mpmc queue over workers, keeping
+// the items that share a key in order.
+package dispatch
+
+import (
+	"lockfree/mpmc"
+	"lockfree/spsc"
+	"sync"
+)
+
+// KeyedDispatcher routes the items of a source queue to a fixed set of
+// workers by key: the items that share a key all go to the same worker, in
+// order, while items with different keys are handled in parallel.  Each
+// worker has an spsc queue of its own, fed by a single router goroutine.  A
+// worker that falls behind fills up its queue and then stalls the router,
+// and thereby the other workers too.
+type KeyedDispatcher struct {
+	src     *mpmc.RingBuffer[interface{}]
+	keyFn   func(interface{}) string
+	handler func(interface{})
+	queues  []*spsc.RingBuffer
+
+	routed  []uint64 // Items routed to each worker, owned by the router.
+	workers sync.WaitGroup
+	done    chan struct{}
+}
+
+// NewKeyedDispatcher starts a router taking the items of src, and workers
+// goroutines, at least 1, calling handler with them.  keyFn gives the key of
+// an item, which decides its worker.  Each worker queues up to size items.
+// The dispatcher becomes the only consumer of src and runs until src is
+// disposed.
+func NewKeyedDispatcher(src *mpmc.RingBuffer[interface{}], workers int, size uint64, keyFn func(interface{}) string, handler func(interface{})) *KeyedDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &KeyedDispatcher{
+		src:     src,
+		keyFn:   keyFn,
+		handler: handler,
+		queues:  make([]*spsc.RingBuffer, workers),
+		routed:  make([]uint64, workers),
+		done:    make(chan struct{}),
+	}
+	d.workers.Add(workers)
+	for i := range d.queues {
+		d.queues[i] = spsc.NewRingBuffer(size)
+		go d.work(d.queues[i])
+	}
+	go d.route()
+	return d
+}
+
+// Worker returns the index of the worker handling the items of key.
+func (d *KeyedDispatcher) Worker(key string) int {
+	// FNV-1a.
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return int(h % uint64(len(d.queues)))
+}
+
+func (d *KeyedDispatcher) route() {
+	defer close(d.done)
+	for {
+		item, err := d.src.Get()
+		if err != nil {
+			break
+		}
+		d.send(item)
+	}
+	// Items still in the source when it was disposed are handled too.
+	for _, item := range d.src.DrainDispose() {
+		d.send(item)
+	}
+	for i, q := range d.queues {
+		q.WaitForReadSeq(d.routed[i], 0)
+		q.Dispose()
+	}
+	d.workers.Wait()
+}
+
+func (d *KeyedDispatcher) send(item interface{}) {
+	i := d.Worker(d.keyFn(item))
+	d.queues[i].Put(item)
+	d.routed[i]++
+}
+
+func (d *KeyedDispatcher) work(q *spsc.RingBuffer) {
+	defer d.workers.Done()
+	for {
+		item, err := q.Get()
+		if err != nil {
+			return
+		}
+		d.handler(item)
+	}
+}
+
+// Wait blocks until the source is disposed and every item it held has been
+// handled, and the dispatcher's goroutines have exited.
+func (d *KeyedDispatcher) Wait() {
+	<-d.done
+}
+
+// Close disposes the source, then waits for the items it held to be handled
+// like Wait.
+func (d *KeyedDispatcher) Close() {
+	d.src.Dispose()
+	d.Wait()
+}
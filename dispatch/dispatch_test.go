@@ -0,0 +1,78 @@
+package dispatch
+
+import (
+	"fmt"
+	"lockfree/mpmc"
+	"sync"
+	"testing"
+	"time"
+)
+
+type event struct {
+	key string
+	seq int
+}
+
+func eventKey(item interface{}) string {
+	return item.(event).key
+}
+
+func TestPerKeyOrder(t *testing.T) {
+	const numKeys, numEvents = 16, 200
+	src := mpmc.NewRingBuffer[interface{}](64)
+
+	var (
+		mu      sync.Mutex
+		next    = map[string]int{}
+		workers = map[int]int{}
+	)
+	d := NewKeyedDispatcher(src, 4, 8, eventKey, func(item interface{}) {
+		e := item.(event)
+		mu.Lock()
+		defer mu.Unlock()
+		if e.seq != next[e.key] {
+			t.Errorf("expected event %d of %s, got %d", next[e.key], e.key, e.seq)
+		}
+		next[e.key]++
+	})
+	for k := 0; k < numKeys; k++ {
+		key := fmt.Sprint("key", k)
+		workers[d.Worker(key)]++
+	}
+	if len(workers) < 2 {
+		t.Fatalf("expected keys spread over workers, got %v", workers)
+	}
+
+	for i := 0; i < numEvents; i++ {
+		for k := 0; k < numKeys; k++ {
+			src.Put(event{fmt.Sprint("key", k), i})
+		}
+	}
+	// Close still handles the items left in the source.
+	d.Close()
+
+	for k := 0; k < numKeys; k++ {
+		if n := next[fmt.Sprint("key", k)]; n != numEvents {
+			t.Fatalf("expected %d events of key%d handled, got %d", numEvents, k, n)
+		}
+	}
+}
+
+func TestParallelWorkers(t *testing.T) {
+	elapsed := func(workers int) time.Duration {
+		src := mpmc.NewRingBuffer[interface{}](64)
+		d := NewKeyedDispatcher(src, workers, 64, eventKey, func(interface{}) {
+			time.Sleep(time.Millisecond)
+		})
+		start := time.Now()
+		for i := 0; i < 64; i++ {
+			src.Put(event{fmt.Sprint("key", i), 0})
+		}
+		d.Close()
+		return time.Since(start)
+	}
+	one, four := elapsed(1), elapsed(4)
+	if four*2 > one {
+		t.Fatalf("expected 4 workers to be at least twice as fast as 1, took %v and %v", four, one)
+	}
+}
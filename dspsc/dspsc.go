@@ -1,12 +1,18 @@
 package dspsc
 
 import (
-	"errors"
+	"lockfree/queue"
 	"runtime"
 	"sync/atomic"
 	"time"
 )
 
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
 // roundUp takes a uint64 greater than 0 and rounds it up to the next
 // power of 2.
 func roundUp(v uint64) uint64 {
@@ -32,17 +38,38 @@ type nodes []node
 // bounded mpmc queue from https://www.1024cores.net/home/lock-free-algorithms/queues/bounded-mpmc-queue.
 type RingBuffer struct {
 	_        [8]uint64
-	write    uint64 // Not shared, owned by producer.
+	write    uint64 // Owned by producer, shared if observable.
 	_        [8]uint64
-	read     uint64 // Not shared, owned by consumer.
+	read     uint64 // Owned by consumer, shared if observable.
 	_        [8]uint64
 	mask     uint64
 	disposed uint64
 	_        [8]uint64
 	nodes    nodes
+
+	// requested is the size passed to NewRingBuffer, before roundUp.
+	requested uint64
+
+	// observable makes the producer and consumer publish write and read
+	// atomically, so that other goroutines may load them.
+	observable bool
+}
+
+// Option configures a RingBuffer at construction time.
+type Option func(rb *RingBuffer)
+
+// WithObservability makes the producer and consumer publish their cursors
+// with atomic stores, so that Len, ReadSeq and WriteSeq are safe to call
+// from any goroutine.  The stores roughly double the cost of Put and Get,
+// which is why this is off by default.
+func WithObservability() Option {
+	return func(rb *RingBuffer) {
+		rb.observable = true
+	}
 }
 
 func (rb *RingBuffer) init(size uint64) {
+	rb.requested = size
 	size = roundUp(size)
 	rb.nodes = make(nodes, size)
 	rb.mask = size - 1 // so we don't have to do this with every put/get operation
@@ -50,9 +77,12 @@ func (rb *RingBuffer) init(size uint64) {
 
 // NewRingBuffer will allocate, initialize, and return a ring buffer
 // with the specified size.
-func NewRingBuffer(size uint64) *RingBuffer {
+func NewRingBuffer(size uint64, opts ...Option) *RingBuffer {
 	rb := &RingBuffer{}
 	rb.init(size)
+	for _, opt := range opts {
+		opt(rb)
+	}
 	return rb
 }
 
@@ -74,6 +104,85 @@ func (rb *RingBuffer) Cap() uint64 {
 	return uint64(len(rb.nodes))
 }
 
+// RequestedCap returns the size the ring buffer was created with, which Cap
+// rounds up to a power of 2.
+func (rb *RingBuffer) RequestedCap() uint64 {
+	return rb.requested
+}
+
+// ReadSeq returns the number of items consumed so far.  Unless the queue
+// was created with WithObservability, only the consumer may call it.
+func (rb *RingBuffer) ReadSeq() uint64 {
+	return atomic.LoadUint64(&rb.read)
+}
+
+// WriteSeq returns the number of items produced so far.  Unless the queue
+// was created with WithObservability, only the producer may call it.
+func (rb *RingBuffer) WriteSeq() uint64 {
+	return atomic.LoadUint64(&rb.write)
+}
+
+// Len returns the number of items in the queue.  It requires the queue to be
+// created with WithObservability, and is a racy snapshot while the producer
+// and consumer are active.
+func (rb *RingBuffer) Len() uint64 {
+	rd := atomic.LoadUint64(&rb.read)
+	return atomic.LoadUint64(&rb.write) - rd
+}
+
+// Inspect calls visit with the sequence and value of every item ready to be
+// consumed, oldest first, without consuming any of them, and stops early if
+// visit returns false.  Only the single consumer may call Inspect.  Items
+// published while it runs may or may not be visited.
+func (rb *RingBuffer) Inspect(visit func(seq uint64, item interface{}) bool) {
+	for seq := rb.read; seq-rb.read < rb.Cap(); seq++ {
+		n := &rb.nodes[seq&rb.mask]
+		if atomic.LoadUint64(&n.ready) == 0 {
+			return
+		}
+		if !visit(seq, n.data) {
+			return
+		}
+	}
+}
+
+// PeekN returns up to n items ready to be consumed, oldest first, without
+// consuming any of them, so the consumer can look ahead before deciding how
+// many to take with CommitN.  It returns fewer items if not that many are
+// ready.  Only the single consumer may call PeekN.
+func (rb *RingBuffer) PeekN(n int) []interface{} {
+	var items []interface{}
+	rb.Inspect(func(_ uint64, item interface{}) bool {
+		if len(items) == n {
+			return false
+		}
+		items = append(items, item)
+		return true
+	})
+	return items
+}
+
+// CommitN consumes the next n items, typically after looking at them with
+// PeekN, and returns the number consumed, which is fewer than n if not that
+// many are ready.  Only the single consumer may call CommitN.
+func (rb *RingBuffer) CommitN(n int) int {
+	var i int
+	for ; i < n; i++ {
+		nd := &rb.nodes[rb.read&rb.mask]
+		if atomic.LoadUint64(&nd.ready) == 0 {
+			break
+		}
+		nd.data = nil
+		if rb.observable {
+			atomic.StoreUint64(&rb.read, rb.read+1)
+		} else {
+			rb.read++
+		}
+		atomic.StoreUint64(&nd.ready, 0) // cache coherence traffic
+	}
+	return i
+}
+
 // Get will return the next item in the queue.  This call will block
 // if the queue is empty.  This call will unblock when an item is added
 // to the queue or Dispose is called on the queue.  An error will be returned
@@ -96,21 +205,44 @@ func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
 	n := &rb.nodes[rb.read&rb.mask]
 	for {
 		if atomic.LoadUint64(&rb.disposed) == 1 {
-			return nil, errors.New(`queue: closed`)
+			return nil, ErrDisposed
 		}
 		rdy := atomic.LoadUint64(&n.ready)
 		if rdy == 1 {
-			rb.read++
-			break
+			return rb.take(n), nil
 		}
 		if timeout > 0 && time.Since(start) >= timeout {
-			return nil, errors.New(`queue: poll timed out`)
+			return nil, ErrTimeout
 		}
 		runtime.Gosched() // free up the cpu before the next iteration
 	}
+}
+
+// TryGet returns the next item in the queue if there is one, without
+// blocking or yielding the processor, e.g. to poll several queues in turn.
+// If the queue is empty, this call will return false.  An error will be
+// returned if the queue is disposed.
+func (rb *RingBuffer) TryGet() (interface{}, bool, error) {
+	if atomic.LoadUint64(&rb.disposed) == 1 {
+		return nil, false, ErrDisposed
+	}
+	n := &rb.nodes[rb.read&rb.mask]
+	if atomic.LoadUint64(&n.ready) == 0 {
+		return nil, false, nil
+	}
+	return rb.take(n), true, nil
+}
+
+// take consumes the published item of n, the node at the read cursor.
+func (rb *RingBuffer) take(n *node) interface{} {
+	if rb.observable {
+		atomic.StoreUint64(&rb.read, rb.read+1)
+	} else {
+		rb.read++
+	}
 	data := n.data
 	atomic.StoreUint64(&n.ready, 0) // cache coherence traffic
-	return data, nil
+	return data
 }
 
 // Put adds the provided item to the queue.  If the queue is full, this
@@ -132,11 +264,15 @@ func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
 	n := &rb.nodes[rb.write&rb.mask]
 	for {
 		if atomic.LoadUint64(&rb.disposed) == 1 {
-			return false, errors.New(`queue: closed`)
+			return false, ErrDisposed
 		}
 		rdy := atomic.LoadUint64(&n.ready)
 		if rdy == 0 {
-			rb.write++
+			if rb.observable {
+				atomic.StoreUint64(&rb.write, rb.write+1)
+			} else {
+				rb.write++
+			}
 			break
 		}
 		// Full.
@@ -1,7 +1,11 @@
 package dspsc
 
 import (
+	"lockfree/internal/queuetest"
+	"math"
+	"runtime"
 	"testing"
+	"unsafe"
 )
 
 func BenchmarkChannel(b *testing.B) {
@@ -33,3 +37,212 @@ func BenchmarkDSPSC(b *testing.B) {
 		q.Put(`a`)
 	}
 }
+
+func TestObservability(t *testing.T) {
+	const numItems = 10_000
+	q := NewRingBuffer(16, WithObservability())
+
+	go func() {
+		for i := 0; i < numItems; i++ {
+			q.Put(i)
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < numItems; i++ {
+			q.Get()
+		}
+	}()
+
+	// Monitor.
+	for {
+		rd, wr := q.ReadSeq(), q.WriteSeq()
+		if l := q.Len(); l > q.Cap()+1 {
+			t.Fatalf("unexpected length %d", l)
+		}
+		if rd > numItems || wr > numItems {
+			t.Fatalf("cursors out of range: read %d, write %d", rd, wr)
+		}
+		select {
+		case <-done:
+			if rd, wr := q.ReadSeq(), q.WriteSeq(); rd != numItems || wr != numItems {
+				t.Fatalf("expected both cursors at %d, got read %d, write %d", numItems, rd, wr)
+			}
+			if l := q.Len(); l != 0 {
+				t.Fatalf("expected an empty queue, got %d", l)
+			}
+			return
+		default:
+		}
+		runtime.Gosched()
+	}
+}
+
+func BenchmarkDSPSCObservable(b *testing.B) {
+	q := NewRingBuffer(8192, WithObservability())
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			_, _ = q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		_ = q.Put(`a`)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	q := NewRingBuffer(4)
+	q.Put(0)
+	q.Get()
+	for i := 1; i <= 4; i++ {
+		q.Put(i)
+	}
+
+	var seqs []uint64
+	var items []interface{}
+	q.Inspect(func(seq uint64, item interface{}) bool {
+		seqs = append(seqs, seq)
+		items = append(items, item)
+		return true
+	})
+	if len(items) != 4 {
+		t.Fatalf("expected 4 items, got %v", items)
+	}
+	for i := range items {
+		if seqs[i] != uint64(i+1) || items[i] != i+1 {
+			t.Fatalf("expected item %d at sequence %d, got %v at %d", i+1, i+1, items[i], seqs[i])
+		}
+	}
+
+	var visited int
+	q.Inspect(func(uint64, interface{}) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Fatalf("expected inspect to stop after 2 items, visited %d", visited)
+	}
+
+	for i := 1; i <= 4; i++ {
+		if got, _ := q.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	q := NewRingBuffer(4)
+	if item, ok, err := q.TryGet(); ok || err != nil {
+		t.Fatalf("expected nothing from an empty queue, got %v, %v, %v", item, ok, err)
+	}
+	for i := 0; i < 3; i++ {
+		q.Put(i)
+	}
+	for i := 0; i < 3; i++ {
+		if item, ok, err := q.TryGet(); item != i || !ok || err != nil {
+			t.Fatalf("expected %d, got %v, %v, %v", i, item, ok, err)
+		}
+	}
+	if _, ok, _ := q.TryGet(); ok {
+		t.Fatal("expected nothing once drained")
+	}
+	q.Put(3)
+	q.Dispose()
+	if _, _, err := q.TryGet(); err != ErrDisposed {
+		t.Fatalf("expected ErrDisposed, got %v", err)
+	}
+}
+
+// TestNodeSize documents the size of a node on 64-bit platforms: a uint64 and
+// an interface{}, so nodes don't line up with 64 byte cache lines and some
+// straddle two.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node{}); size != 24 {
+		t.Fatalf("expected a 24 byte node, got %d", size)
+	}
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer(64)
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.read >= uint64(ops) {
+		t.Fatalf("expected the read sequence to have wrapped around, got %d", q.read)
+	}
+}
+
+func TestPeekNCommitN(t *testing.T) {
+	q := NewRingBuffer(8)
+	if items := q.PeekN(3); len(items) != 0 {
+		t.Fatalf("expected nothing to peek at, got %v", items)
+	}
+	for _, item := range []string{"a", "a", "b", "c"} {
+		q.Put(item)
+	}
+
+	// Coalesce the run of equal items at the head.
+	items := q.PeekN(3)
+	if len(items) != 3 || items[0] != "a" || items[1] != "a" || items[2] != "b" {
+		t.Fatalf("expected [a a b], got %v", items)
+	}
+	run := 1
+	for run < len(items) && items[run] == items[0] {
+		run++
+	}
+	if n := q.CommitN(run); n != 2 {
+		t.Fatalf("expected 2 items committed, got %d", n)
+	}
+
+	if items := q.PeekN(5); len(items) != 2 || items[0] != "b" || items[1] != "c" {
+		t.Fatalf("expected [b c], got %v", items)
+	}
+	if n := q.CommitN(5); n != 2 {
+		t.Fatalf("expected only 2 ready items committed, got %d", n)
+	}
+	q.Put("d")
+	if got, _ := q.Get(); got != "d" {
+		t.Fatalf("expected d, got %v", got)
+	}
+}
+
+func TestConservation(t *testing.T) {
+	q := NewRingBuffer(512)
+	queuetest.Conservation(t, q, q.counters)
+}
+
+func TestRequestedCap(t *testing.T) {
+	q := NewRingBuffer(1000)
+	if c := q.RequestedCap(); c != 1000 {
+		t.Fatalf("expected a requested capacity of 1000, got %d", c)
+	}
+	if c := q.Cap(); c != 1024 {
+		t.Fatalf("expected a capacity of 1024, got %d", c)
+	}
+}
@@ -0,0 +1,17 @@
+package dspsc
+
+import "sync/atomic"
+
+// fastForward moves the cursors of an empty queue to seq, as if seq items
+// had gone through it, to test sequences wrapping around.
+func (rb *RingBuffer) fastForward(seq uint64) {
+	rb.write, rb.read = seq, seq
+}
+
+// counters returns the number of items put, got, and ready in the queue.
+func (rb *RingBuffer) counters() (put, got, length uint64) {
+	for i := range rb.nodes {
+		length += atomic.LoadUint64(&rb.nodes[i].ready)
+	}
+	return rb.write, rb.read, length
+}
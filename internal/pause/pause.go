@@ -0,0 +1,2 @@
+// Package pause provides the CPU's spin-wait hint to busy-wait loops.
+package pause
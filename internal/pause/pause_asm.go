@@ -0,0 +1,9 @@
+//go:build amd64 || arm64
+// +build amd64 arm64
+
+package pause
+
+// Pause executes PAUSE on amd64 and YIELD on arm64, telling the CPU it is
+// in a spin-wait loop: it backs off briefly, saving power and leaving the
+// core's resources to a hyperthread sibling, without yielding the goroutine.
+func Pause()
@@ -0,0 +1,7 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package pause
+
+// Pause is a no-op on architectures without a spin-wait hint.
+func Pause() {}
@@ -0,0 +1,9 @@
+package pause
+
+import "testing"
+
+func BenchmarkPause(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Pause()
+	}
+}
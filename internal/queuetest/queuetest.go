@@ -0,0 +1,68 @@
+// Package queuetest provides assertions shared by the tests of the ring
+// buffers in this module.
+package queuetest
+
+import (
+	"lockfree/queue"
+	"testing"
+)
+
+// Counters returns the total number of items put to and got from a queue,
+// read off its raw counters, and the number of items in it.
+type Counters func() (put, got, length uint64)
+
+// ConservationCheck fails t unless every item put was either got or is still
+// in the queue, exactly once: put == got + length.  The counters must be
+// read at a quiescent point, with no put or get in progress.
+func ConservationCheck(t testing.TB, put, got, length uint64) {
+	t.Helper()
+	if put != got+length {
+		t.Fatalf("conservation violated: %d put, %d got and %d in the queue, %d items unaccounted for",
+			put, got, length, int64(put-got-length))
+	}
+}
+
+// Conservation runs a producer and a consumer through q concurrently, the
+// producer putting more items than the consumer gets, and checks the
+// counters against what they did, the conservation of items, and that the
+// items left in q come out in order.  q must hold at least 512 items.
+func Conservation(t *testing.T, q queue.Queue, counters Counters) {
+	t.Helper()
+	const (
+		numPut = 1_000
+		numGot = 700
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < numPut; i++ {
+			if err := q.Put(i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < numGot; i++ {
+		if item, err := q.Get(); item != i || err != nil {
+			t.Fatalf("expected %d, got %v, %v", i, item, err)
+		}
+	}
+	<-done
+
+	put, got, length := counters()
+	if put != numPut || got != numGot {
+		t.Fatalf("expected counters of %d put and %d got, got %d and %d", numPut, numGot, put, got)
+	}
+	ConservationCheck(t, put, got, length)
+
+	for i := uint64(0); i < length; i++ {
+		if item, err := q.Get(); item != int(got+i) || err != nil {
+			t.Fatalf("expected %d, got %v, %v", got+i, item, err)
+		}
+	}
+	put, got, length = counters()
+	ConservationCheck(t, put, got, length)
+	if got != numPut || length != 0 {
+		t.Fatalf("expected every item got once drained, got %d got and %d in the queue", got, length)
+	}
+}
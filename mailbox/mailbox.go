@@ -0,0 +1,104 @@
+package mailbox
+
+import (
+	"lockfree/queue"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// letter boxes a value, so that the slot can hold a nil value and still be
+// told apart from an empty slot.
+type letter struct {
+	data interface{}
+}
+
+// Mailbox is a lockfree single-slot queue for values where only the latest
+// matters, such as state updates.  Put overwrites whatever value was not
+// consumed yet, and Get takes the value out, so each value is returned at
+// most once.  Any number of goroutines may put and get.
+type Mailbox struct {
+	_           [8]uint64
+	slot        unsafe.Pointer // Shared. *letter, nil when empty.
+	_           [8]uint64
+	disposed    uint64
+	overwritten uint64 // Shared.
+}
+
+// NewMailbox will allocate, initialize, and return an empty mailbox.
+func NewMailbox() *Mailbox {
+	return &Mailbox{}
+}
+
+// Dispose will dispose of this mailbox and free any blocked threads in the
+// Get method.  Calling Put or Get on a disposed mailbox will return an
+// error.
+func (mb *Mailbox) Dispose() {
+	atomic.CompareAndSwapUint64(&mb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this mailbox has been
+// disposed.
+func (mb *Mailbox) IsDisposed() bool {
+	return atomic.LoadUint64(&mb.disposed) == 1
+}
+
+// Overwritten returns the number of values Put replaced before they were
+// consumed.
+func (mb *Mailbox) Overwritten() uint64 {
+	return atomic.LoadUint64(&mb.overwritten)
+}
+
+// Get will return the latest value put in the mailbox, emptying it.  This
+// call will block if the mailbox is empty.  This call will unblock when a
+// value is put or Dispose is called on the mailbox.  An error will be
+// returned if the mailbox is disposed.
+func (mb *Mailbox) Get() (interface{}, error) {
+	return mb.Poll(0)
+}
+
+// Poll will return the latest value put in the mailbox, emptying it.  This
+// call will block if the mailbox is empty.  This call will unblock when a
+// value is put, Dispose is called on the mailbox, or the timeout is
+// reached.  An error will be returned if the mailbox is disposed or a
+// timeout occurs.  A non-positive timeout will block indefinitely.
+func (mb *Mailbox) Poll(timeout time.Duration) (interface{}, error) {
+	var start time.Time
+	if timeout > 0 {
+		start = time.Now()
+	}
+	for {
+		if atomic.LoadUint64(&mb.disposed) == 1 {
+			return nil, ErrDisposed
+		}
+		if atomic.LoadPointer(&mb.slot) != nil {
+			if l := (*letter)(atomic.SwapPointer(&mb.slot, nil)); l != nil {
+				return l.data, nil
+			}
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, ErrTimeout
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+}
+
+// Put sets the value in the mailbox, replacing the previous one if it was
+// not consumed yet.  It never blocks.  An error will be returned if the
+// mailbox is disposed.
+func (mb *Mailbox) Put(item interface{}) error {
+	if atomic.LoadUint64(&mb.disposed) == 1 {
+		return ErrDisposed
+	}
+	if atomic.SwapPointer(&mb.slot, unsafe.Pointer(&letter{item})) != nil {
+		atomic.AddUint64(&mb.overwritten, 1)
+	}
+	return nil
+}
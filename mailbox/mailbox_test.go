@@ -0,0 +1,115 @@
+package mailbox
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLatestOnly(t *testing.T) {
+	mb := NewMailbox()
+	for i := 0; i < 100; i++ {
+		mb.Put(i)
+	}
+	if item, err := mb.Get(); item != 99 || err != nil {
+		t.Fatalf("expected the latest value 99, got %v, %v", item, err)
+	}
+	if n := mb.Overwritten(); n != 99 {
+		t.Fatalf("expected 99 values overwritten, got %d", n)
+	}
+	// A consumed value isn't returned twice.
+	if item, err := mb.Poll(time.Millisecond); err == nil {
+		t.Fatalf("expected an empty mailbox to time out, got %v", item)
+	}
+
+	mb.Put(nil)
+	if item, err := mb.Poll(time.Millisecond); item != nil || err != nil {
+		t.Fatalf("expected a nil value, got %v, %v", item, err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const numProducers, numItems = 4, 10_000
+	mb := NewMailbox()
+
+	var wg sync.WaitGroup
+	wg.Add(numProducers)
+	for p := 0; p < numProducers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < numItems; i++ {
+				mb.Put(p*numItems + i)
+			}
+		}(p)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Each producer's values arrive in order, each at most once.
+	last := make([]int, numProducers)
+	for p := range last {
+		last[p] = -1
+	}
+	got := 0
+	for finished := false; ; {
+		item, err := mb.Poll(10 * time.Millisecond)
+		if err != nil {
+			if finished {
+				break
+			}
+			// One more poll for a value put right before done.
+			select {
+			case <-done:
+				finished = true
+			default:
+			}
+			continue
+		}
+		p, i := item.(int)/numItems, item.(int)%numItems
+		if i <= last[p] {
+			t.Fatalf("expected values of producer %d after %d, got %d", p, last[p], i)
+		}
+		last[p] = i
+		got++
+	}
+	if uint64(got)+mb.Overwritten() != numProducers*numItems {
+		t.Fatalf("expected every value either got or overwritten, got %d and %d overwritten", got, mb.Overwritten())
+	}
+}
+
+func TestDispose(t *testing.T) {
+	mb := NewMailbox()
+	done := make(chan error)
+	go func() {
+		_, err := mb.Get()
+		done <- err
+	}()
+	time.Sleep(time.Millisecond)
+	mb.Dispose()
+	if err := <-done; err == nil {
+		t.Fatal("expected Dispose to unblock Get with an error")
+	}
+	if err := mb.Put(1); err == nil {
+		t.Fatal("expected Put on a disposed mailbox to fail")
+	}
+}
+
+func BenchmarkMailbox(b *testing.B) {
+	mb := NewMailbox()
+	go func() {
+		for {
+			if _, err := mb.Get(); err != nil {
+				return
+			}
+		}
+	}()
+	defer mb.Dispose()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mb.Put(i)
+	}
+}
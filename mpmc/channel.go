@@ -0,0 +1,51 @@
+package mpmc
+
+import "context"
+
+// DrainTo moves the queue's items to ch until the queue is disposed or done
+// is closed, so that select based code can wait on the queue alongside
+// timers and contexts.  It takes the place of a consumer, competing with the
+// others for items, and blocks while ch is full.  The item it holds when
+// done is closed, if it is still waiting to send it, is dropped.  A nil done
+// runs DrainTo until the queue is disposed.
+func (rb *RingBuffer[T]) DrainTo(ch chan<- T, done <-chan struct{}) {
+	ctx := context.Background()
+	if done != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	for {
+		item, err := rb.GetContext(ctx)
+		if err != nil {
+			return
+		}
+		select {
+		case ch <- item:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Channel starts a goroutine moving the queue's items, with DrainTo, to the
+// returned channel, which buffers up to Cap more items.  The goroutine runs
+// until the queue is disposed, then closes the channel, whose items can
+// still be received: disposing the queue is how to stop it.  It must not be
+// abandoned with items pending in the queue though, as the goroutine would
+// block sending them forever.
+func (rb *RingBuffer[T]) Channel() <-chan T {
+	ch := make(chan T, rb.Cap())
+	go func() {
+		defer close(ch)
+		rb.DrainTo(ch, nil)
+	}()
+	return ch
+}
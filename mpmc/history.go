@@ -0,0 +1,66 @@
+package mpmc
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is the length of a queue at some point in time.
+type Sample struct {
+	Time time.Time
+	Len  uint64 // Metrics' ApproxLen.
+}
+
+// history is a ring of the latest samples.
+type history struct {
+	mu      sync.Mutex
+	samples []Sample
+	next    int // Index the next sample goes to.
+	full    bool
+}
+
+// WithHistory makes the queue keep its latest n samples, at least 1, taken
+// with Sample, for History to return.
+func WithHistory(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			n = 1
+		}
+		o.history = &history{samples: make([]Sample, n)}
+	}
+}
+
+// Sample records the current length of the queue in its history, dropping
+// the oldest sample once the history is full.  The caller decides when to
+// sample, e.g. on a ticker, as the queue runs no goroutine of its own.  It
+// is a no-op unless the queue was created with WithHistory.
+func (rb *RingBuffer[T]) Sample() {
+	h := rb.history
+	if h == nil {
+		return
+	}
+	s := Sample{Time: time.Now(), Len: rb.Metrics().ApproxLen}
+	h.mu.Lock()
+	h.samples[h.next] = s
+	h.next++
+	if h.next == len(h.samples) {
+		h.next = 0
+		h.full = true
+	}
+	h.mu.Unlock()
+}
+
+// History returns the samples in the queue's history, oldest first, in a new
+// slice.  It returns nil unless the queue was created with WithHistory.
+func (rb *RingBuffer[T]) History() []Sample {
+	h := rb.history
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]Sample(nil), h.samples[:h.next]...)
+	}
+	return append(append([]Sample(nil), h.samples[h.next:]...), h.samples[:h.next]...)
+}
@@ -0,0 +1,75 @@
+package mpmc
+
+import "sync/atomic"
+
+// Metrics is a best-effort snapshot of a queue's health, for exporters such
+// as Prometheus.  Its fields are loaded one after the other, not atomically
+// as a whole, so they may be slightly inconsistent under load.
+type Metrics struct {
+	Capacity      uint64 // Gauge.
+	ApproxLen     uint64 // Gauge, items in the ring and the overflow.
+	TotalPuts     uint64 // Counter.
+	TotalGets     uint64 // Counter.
+	DroppedOffers uint64 // Counter, Offer and friends giving up on a full queue.
+	HighWater     uint64 // Gauge, the largest ApproxLen seen by a put.
+}
+
+// metrics holds the counters that can't be derived from the cursors.
+type metrics struct {
+	dropped   uint64 // Shared.
+	highWater uint64 // Shared.
+}
+
+// WithMetrics makes the queue count dropped offers and track its high water
+// mark, which Metrics reports.  It costs every put an extra load and, while
+// the queue keeps growing, a CAS.
+func WithMetrics() Option {
+	return func(o *options) {
+		o.metrics = &metrics{}
+	}
+}
+
+func (rb *RingBuffer[T]) recordMetrics(ok bool, err error) {
+	m := rb.metrics
+	if err != nil {
+		return
+	}
+	if !ok {
+		atomic.AddUint64(&m.dropped, 1)
+		return
+	}
+	l := rb.Len() + rb.Overflowed()
+	for {
+		hw := atomic.LoadUint64(&m.highWater)
+		if l <= hw || atomic.CompareAndSwapUint64(&m.highWater, hw, l) {
+			return
+		}
+	}
+}
+
+// Metrics returns a snapshot of the queue's health.  Puts and gets are read
+// off the cursors, so they are always counted, while DroppedOffers and
+// HighWater stay 0 unless the queue was created with WithMetrics.
+func (rb *RingBuffer[T]) Metrics() Metrics {
+	// read first: write only grows, so the length can't come out negative.
+	rd := atomic.LoadUint64(&rb.read)
+	wr := atomic.LoadUint64(&rb.write)
+	m := Metrics{
+		Capacity:  rb.Cap(),
+		ApproxLen: wr - rd,
+		TotalPuts: wr,
+		TotalGets: rd,
+	}
+	if o := rb.overflow; o != nil {
+		spilled := atomic.LoadUint64(&o.spilled)
+		l := atomic.LoadUint64(&o.len)
+		m.ApproxLen += l
+		m.TotalPuts += spilled
+		m.TotalGets += spilled - l
+	}
+	if rb.metrics != nil {
+		m.DroppedOffers = atomic.LoadUint64(&rb.metrics.dropped)
+		m.HighWater = atomic.LoadUint64(&rb.metrics.highWater)
+	}
+	return m
+}
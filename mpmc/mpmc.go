@@ -1,10 +1,21 @@
 package mpmc
 
 import (
-	"errors"
+	"context"
+	"lockfree/internal/pause"
+	"lockfree/queue"
 	"runtime"
+	"runtime/trace"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
 )
 
 // minSize is 2 because size of 1 is invalid: node's position
@@ -12,6 +23,11 @@ import (
 // read, this breaks when size is set to 1.
 const minSize = 2
 
+// parallelInitThreshold is the size from which node positions are
+// initialized by multiple goroutines, to speed up construction of huge
+// buffers.
+const parallelInitThreshold = 1 << 20
+
 // roundUp takes a uint64 greater than 0 and rounds it up to the next
 // power of 2.
 func roundUp(v uint64) uint64 {
@@ -26,16 +42,49 @@ func roundUp(v uint64) uint64 {
 	return v
 }
 
-type node struct {
+type node[T any] struct {
 	position uint64 // Shared.
-	data     interface{}
+	data     T
+}
+
+type nodes[T any] []node[T]
+
+// cause wraps a dispose error so that atomic.Value always stores the same
+// concrete type.
+type cause struct {
+	err error
+}
+
+// fill sets every node's position to its index plus offset.
+func (ns nodes[T]) fill(offset, spread uint64) {
+	for i := range ns {
+		ns[i].position = (offset + uint64(i)) >> spread
+	}
 }
 
-type nodes []node
+// parallelFill is fill(0, spread) split across GOMAXPROCS goroutines.
+func (ns nodes[T]) parallelFill(spread uint64) {
+	procs := runtime.GOMAXPROCS(0)
+	chunk := (len(ns) + procs - 1) / procs
+	var wg sync.WaitGroup
+	for lo := 0; lo < len(ns); lo += chunk {
+		hi := lo + chunk
+		if hi > len(ns) {
+			hi = len(ns)
+		}
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			ns[lo:hi].fill(uint64(lo), spread)
+		}(lo, hi)
+	}
+	wg.Wait()
+}
 
-// RingBuffer is a MPMC lockfree queue. This implementation is based on Dmitry's
-// bounded mpmc queue from https://www.1024cores.net/home/lock-free-algorithms/queues/bounded-mpmc-queue.
-type RingBuffer struct {
+// RingBuffer is a MPMC lockfree queue of items of type T. This implementation
+// is based on Dmitry's bounded mpmc queue from
+// https://www.1024cores.net/home/lock-free-algorithms/queues/bounded-mpmc-queue.
+type RingBuffer[T any] struct {
 	_        [8]uint64
 	write    uint64 // Shared only with producers.
 	_        [8]uint64
@@ -44,52 +93,501 @@ type RingBuffer struct {
 	mask     uint64
 	disposed uint64
 	_        [8]uint64
-	nodes    nodes
+	nodes    nodes[T]
+
+	disposeOnce sync.Once
+	cause       atomic.Value // cause, set before disposed.
+
+	options
+
+	// contention counts failed CAS attempts per node. Nil unless the
+	// queue was created with WithContentionTracking.
+	contention []uint64
+
+	// overflow absorbs puts that do not fit in the ring. Nil unless the
+	// queue was created with WithOverflow.
+	overflow *overflow[T]
+
+	// tombstones holds the positions of slots claimed by a put that found
+	// the queue disposed right after claiming them, which are published
+	// but hold no item.  Only touched once the queue is disposed.
+	tombMu     sync.Mutex
+	tombstones map[uint64]struct{}
+
+	// yield, when set, is called between the steps of the put and get
+	// protocols with the name of the step just taken, so that tests can
+	// control how concurrent calls interleave. Nil outside of tests.
+	yield func(step string)
+
+	// producers is the number of registered producers, only bookkeeping
+	// for RegisterProducer and friends.
+	producers int64 // Shared.
+
+	// spread is log2 of the number of nodes per slot: slot i is node
+	// i<<spread and the others are padding. Only set by
+	// NewRingBufferPaddedNodes.
+	spread uint64
+}
+
+// options holds the settings of a RingBuffer that don't depend on its item
+// type, so that an Option works for any RingBuffer.
+type options struct {
+	// contentionTracking and overflowing make the constructor allocate
+	// contention and overflow respectively.
+	contentionTracking bool
+	overflowing        bool
+
+	// parking lets idle consumers sleep instead of spin. Nil unless the
+	// queue was created with WithParking.
+	parking *parking
+
+	// occupancy counts puts by the queue's fill level right after them.
+	// Nil unless the queue was created with WithOccupancyHistogram.
+	occupancy *[4]uint64
+
+	// onDispose is called once the queue is disposed. Nil unless the queue
+	// was created with WithOnDispose.
+	onDispose func()
+
+	// metrics holds the counters Metrics reports beyond the cursors. Nil
+	// unless the queue was created with WithMetrics.
+	metrics *metrics
+
+	// history holds the latest samples taken with Sample. Nil unless the
+	// queue was created with WithHistory.
+	history *history
+
+	// disposeErr replaces the default error of calls on a disposed queue.
+	// Nil unless the queue was created with WithDisposeError.
+	disposeErr error
+
+	// timeoutCheckEvery is how many spins Poll does between clock reads.
+	timeoutCheckEvery uint64
+
+	// tracing marks blocking waits as runtime/trace regions. Only set when
+	// the queue was created with WithTracing.
+	tracing bool
+
+	// pureSpin makes the wait loops spin without yielding, and cpuPause
+	// with a spin-wait hint. Only set when the queue was created with
+	// WithPureSpin or WithCPUPause respectively.
+	pureSpin bool
+	cpuPause bool
+
+	// replay keeps consumed items in their slots for SeekRead. Only set
+	// when the queue was created with WithReplay.
+	replay bool
+}
+
+// cacheLine is the cache line size, in bytes, padded nodes are sized for.
+const cacheLine = 64
+
+// paddedSpread returns the smallest spread that makes a slot of a
+// RingBuffer[T] a whole number of cache lines.
+func paddedSpread[T any]() uint64 {
+	var spread uint64
+	for (unsafe.Sizeof(node[T]{})<<spread)%cacheLine != 0 {
+		spread++
+	}
+	return spread
 }
 
-func (rb *RingBuffer) init(size uint64) {
+// defaultTimeoutCheckEvery is the default number of spins Poll does between
+// clock reads.
+const defaultTimeoutCheckEvery = 64
+
+// Option configures a RingBuffer at construction time.
+type Option func(o *options)
+
+// WithContentionTracking enables per-node counting of failed CAS attempts
+// in Put and Get. This is a debugging aid: it allocates a counter per node
+// and adds an atomic store to every failed CAS, so leave it off in
+// production.
+func WithContentionTracking() Option {
+	return func(o *options) {
+		o.contentionTracking = true
+	}
+}
+
+func (rb *RingBuffer[T]) init(size uint64) {
 	size = roundUp(size)
-	rb.nodes = make(nodes, size)
-	for i := uint64(0); i < size; i++ {
-		rb.nodes[i] = node{position: i}
+	rb.nodes = make(nodes[T], size<<rb.spread)
+	if size >= parallelInitThreshold {
+		rb.nodes.parallelFill(rb.spread)
+	} else {
+		rb.nodes.fill(0, rb.spread)
 	}
 	rb.mask = size - 1 // so we don't have to do this with every put/get operation
 }
 
+// WithTimeoutCheckInterval makes Poll read the clock only every n spins of
+// its wait loop, 64 by default, rather than on every spin, as the clock read
+// would dominate a tight spin.  A timeout then fires up to n spins late.
+func WithTimeoutCheckInterval(n uint64) Option {
+	return func(o *options) {
+		if n == 0 {
+			n = 1
+		}
+		o.timeoutCheckEvery = n
+	}
+}
+
+// WithPureSpin makes the wait loops of Get, Put and friends busy-wait
+// without calling runtime.Gosched, for a goroutine locked to a thread on an
+// isolated core, where yielding only invites the scheduler to run something
+// else there.  It trades a whole core for the lowest wake-up latency, and
+// without a core to spare it starves the other goroutines until the runtime
+// preempts the spinner.
+func WithPureSpin() Option {
+	return func(o *options) {
+		o.pureSpin = true
+	}
+}
+
+// WithCPUPause is WithPureSpin executing the CPU's spin-wait hint, PAUSE on
+// amd64 and YIELD on arm64, on every iteration of the wait loops.  The
+// hint saves power and leaves more of the core to a hyperthread sibling,
+// at the cost of a slightly slower reaction.  It is a no-op on other
+// architectures.
+func WithCPUPause() Option {
+	return func(o *options) {
+		o.pureSpin = true
+		o.cpuPause = true
+	}
+}
+
+// spin is called on every iteration of a wait loop.
+func (rb *RingBuffer[T]) spin() {
+	switch {
+	case rb.cpuPause:
+		pause.Pause()
+	case !rb.pureSpin:
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+}
+
+// WithTracing marks the time Get, Poll and Put spend blocked on an empty or
+// full queue as mpmc.get-blocked-empty and mpmc.put-blocked-full regions, so
+// that it shows up in go tool trace instead of looking like busy CPU.
+func WithTracing() Option {
+	return func(o *options) {
+		o.tracing = true
+	}
+}
+
+// WithDisposeError makes every method that fails because the queue is
+// disposed return err, e.g. io.EOF for a consumer bridging to an io.Reader,
+// instead of the default error.
+func WithDisposeError(err error) Option {
+	return func(o *options) {
+		o.disposeErr = err
+	}
+}
+
+// disposedErr returns the error for calls on a disposed queue.
+func (rb *RingBuffer[T]) disposedErr() error {
+	if rb.disposeErr != nil {
+		return rb.disposeErr
+	}
+	return ErrDisposed
+}
+
+// WithOnDispose calls onDispose exactly once, on the goroutine whose call to
+// Dispose or DisposeWithError disposed of the queue, to release resources
+// tied to it.
+func WithOnDispose(onDispose func()) Option {
+	return func(o *options) {
+		o.onDispose = onDispose
+	}
+}
+
 // NewRingBuffer will allocate, initialize, and return a ring buffer
-// with the specified size.
-func NewRingBuffer(size uint64) *RingBuffer {
-	rb := &RingBuffer{}
+// of items of type T with the specified size.
+func NewRingBuffer[T any](size uint64, opts ...Option) *RingBuffer[T] {
+	return newRingBuffer(&RingBuffer[T]{}, size, opts)
+}
+
+// NewRingBufferPaddedNodes is NewRingBuffer with every node padded to a
+// multiple of the cache line size, so producers and consumers working on
+// adjacent slots don't falsely share a line.  This costs several times the
+// memory of packed nodes: see BenchmarkMPMCPaddedNodes for whether it pays.
+func NewRingBufferPaddedNodes[T any](size uint64, opts ...Option) *RingBuffer[T] {
+	return newRingBuffer(&RingBuffer[T]{spread: paddedSpread[T]()}, size, opts)
+}
+
+func newRingBuffer[T any](rb *RingBuffer[T], size uint64, opts []Option) *RingBuffer[T] {
 	if size < minSize {
 		size = minSize
 	}
+	rb.timeoutCheckEvery = defaultTimeoutCheckEvery
+	for _, opt := range opts {
+		opt(&rb.options)
+	}
 	rb.init(size)
+	if rb.contentionTracking {
+		rb.contention = make([]uint64, rb.Cap())
+	}
+	if rb.overflowing {
+		rb.overflow = &overflow[T]{}
+	}
 	return rb
 }
 
 // Dispose will dispose of this queue and free any blocked threads
 // in the Put and/or Get methods.  Calling those methods on a disposed
 // queue will return an error.
-func (rb *RingBuffer) Dispose() {
-	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+func (rb *RingBuffer[T]) Dispose() {
+	rb.DisposeWithError(nil)
+}
+
+// DisposeWithError disposes of this queue like Dispose and records err as
+// the cause, which Cause reports.  Only the first call to Dispose or
+// DisposeWithError has any effect.
+func (rb *RingBuffer[T]) DisposeWithError(err error) {
+	rb.disposeOnce.Do(func() {
+		rb.cause.Store(cause{err})
+		if atomic.CompareAndSwapUint64(&rb.disposed, 0, 1) && rb.parking != nil {
+			close(rb.parking.done)
+		}
+		if rb.onDispose != nil {
+			rb.onDispose()
+		}
+	})
+}
+
+// Restart makes a disposed queue usable again, reusing its nodes instead of
+// allocating a new queue.  As the state a fault left the queue in can't be
+// trusted, every item still in it, including the overflow, is dropped and the
+// queue starts over empty.  Statistics such as NodeContention are kept.
+// Restart must not be called concurrently with any other method.
+func (rb *RingBuffer[T]) Restart() {
+	var zero T
+	for i := range rb.nodes {
+		rb.nodes[i].data = zero
+	}
+	rb.nodes.fill(0, rb.spread)
+	atomic.StoreUint64(&rb.write, 0)
+	atomic.StoreUint64(&rb.read, 0)
+	if rb.overflow != nil {
+		rb.overflow.items = nil
+		atomic.StoreUint64(&rb.overflow.len, 0)
+		atomic.StoreUint64(&rb.overflow.spilled, 0)
+	}
+	if p := rb.parking; p != nil {
+		atomic.StoreInt32(&p.waiters, 0)
+		select {
+		case <-p.wake:
+		default:
+		}
+		p.done = make(chan struct{})
+	}
+	rb.tombstones = nil
+	rb.disposeOnce = sync.Once{}
+	rb.cause.Store(cause{})
+	atomic.StoreUint64(&rb.disposed, 0)
+}
+
+// Cause returns the error the queue was disposed with, or nil if it was
+// disposed with Dispose or has not been disposed.
+func (rb *RingBuffer[T]) Cause() error {
+	c, _ := rb.cause.Load().(cause)
+	return c.err
+}
+
+// DrainDispose disposes of this queue, then claims every item still in it
+// and returns them in order.  Items taken concurrently by other consumers
+// are not returned.  Puts racing with the dispose either fail or succeed
+// before the drain is done, which waits for them, so every item a put
+// reported as enqueued is either taken by a consumer or returned here.
+func (rb *RingBuffer[T]) DrainDispose() []T {
+	rb.Dispose()
+
+	var items []T
+	pos := atomic.LoadUint64(&rb.read)
+	for {
+		n := rb.node(pos)
+		seq := atomic.LoadUint64(&n.position)
+		if seq == pos {
+			if atomic.LoadUint64(&rb.write) == pos {
+				break
+			}
+			// Claimed by a put that started before the dispose, which
+			// is about to publish it.
+			runtime.Gosched()
+			continue
+		}
+		if seq == pos+1 && atomic.CompareAndSwapUint64(&rb.read, pos, pos+1) {
+			if !rb.buried(pos) {
+				items = append(items, n.data)
+			}
+			rb.clear(n)
+			atomic.StoreUint64(&n.position, pos+rb.mask+1) // cache coherence traffic
+		}
+		pos = atomic.LoadUint64(&rb.read)
+	}
+	if rb.overflow != nil {
+		for {
+			data, ok := rb.overflow.pop()
+			if !ok {
+				break
+			}
+			items = append(items, data)
+		}
+	}
+	return items
+}
+
+// bury marks slot pos as published without an item, for a put that found
+// the queue disposed after claiming it.
+func (rb *RingBuffer[T]) bury(pos uint64) {
+	rb.tombMu.Lock()
+	if rb.tombstones == nil {
+		rb.tombstones = make(map[uint64]struct{})
+	}
+	rb.tombstones[pos] = struct{}{}
+	rb.tombMu.Unlock()
+}
+
+// buried reports whether the claimed slot pos was buried.  Only disposed
+// queues have buried slots, so it costs a single load until then.
+func (rb *RingBuffer[T]) buried(pos uint64) bool {
+	if atomic.LoadUint64(&rb.disposed) == 0 {
+		return false
+	}
+	rb.tombMu.Lock()
+	_, ok := rb.tombstones[pos]
+	rb.tombMu.Unlock()
+	return ok
+}
+
+// clear drops the reference a consumed node holds to its item, so that the
+// item can be garbage collected, unless the queue keeps it for replay.  It
+// must be called before the node's position is released to producers.
+func (rb *RingBuffer[T]) clear(n *node[T]) {
+	if !rb.replay {
+		var zero T
+		n.data = zero
+	}
 }
 
 // IsDisposed will return a bool indicating if this queue has been
 // disposed.
-func (rb *RingBuffer) IsDisposed() bool {
+func (rb *RingBuffer[T]) IsDisposed() bool {
 	return atomic.LoadUint64(&rb.disposed) == 1
 }
 
+// node returns the node of slot pos.
+func (rb *RingBuffer[T]) node(pos uint64) *node[T] {
+	return &rb.nodes[(pos&rb.mask)<<rb.spread]
+}
+
 // Cap returns the capacity of this ring buffer.
-func (rb *RingBuffer) Cap() uint64 {
-	return uint64(len(rb.nodes))
+func (rb *RingBuffer[T]) Cap() uint64 {
+	return rb.mask + 1
+}
+
+// Len returns the number of items in the ring, not counting the overflow.
+// Under concurrent puts and gets it is a racy snapshot: it may be slightly
+// stale, or momentarily exceed Cap as it counts claimed slots that are not
+// published yet, which is good enough for gauges and backpressure
+// heuristics.
+func (rb *RingBuffer[T]) Len() uint64 {
+	// read first: write only grows, so the length can't come out negative.
+	rd := atomic.LoadUint64(&rb.read)
+	return atomic.LoadUint64(&rb.write) - rd
+}
+
+// WithOccupancyHistogram makes every put record how full the queue is
+// right after it, in four buckets of a quarter of the capacity each.
+func WithOccupancyHistogram() Option {
+	return func(o *options) {
+		o.occupancy = &[4]uint64{}
+	}
+}
+
+// OccupancyHistogram returns the number of puts that left the queue 0-25%,
+// 25-50%, 50-75% and 75-100% full respectively.  It returns all zeros if
+// the queue was not created with WithOccupancyHistogram.
+func (rb *RingBuffer[T]) OccupancyHistogram() [4]uint64 {
+	var h [4]uint64
+	if rb.occupancy != nil {
+		for i := range h {
+			h[i] = atomic.LoadUint64(&rb.occupancy[i])
+		}
+	}
+	return h
+}
+
+func (rb *RingBuffer[T]) recordOccupancy() {
+	b := rb.Len() * 4 / rb.Cap()
+	// Racy loads may overshoot the capacity.
+	if b > 3 {
+		b = 3
+	}
+	atomic.AddUint64(&rb.occupancy[b], 1)
+}
+
+// NodeContention returns the number of failed CAS attempts observed on
+// each node, indexed by slot. It returns nil if the queue was not created
+// with WithContentionTracking. A slot with a disproportionate count hints
+// at hot-slotting between producers and consumers.
+func (rb *RingBuffer[T]) NodeContention() []uint64 {
+	if rb.contention == nil {
+		return nil
+	}
+	counts := make([]uint64, len(rb.contention))
+	for i := range rb.contention {
+		counts[i] = atomic.LoadUint64(&rb.contention[i])
+	}
+	return counts
+}
+
+func (rb *RingBuffer[T]) contended(pos uint64) {
+	if rb.contention != nil {
+		atomic.AddUint64(&rb.contention[pos&rb.mask], 1)
+	}
+}
+
+// WaitForWriteSeq blocks until producers have claimed at least seq slots
+// in total, i.e. until the write cursor reaches seq.  The latest puts may
+// still be publishing their item when it returns, and puts spilled to the
+// overflow are not counted.  This call will unblock when the cursor gets
+// there, Dispose is called on the queue, or the timeout is reached.  An
+// error will be returned if the queue is disposed or a timeout occurs.  A
+// non-positive timeout will block indefinitely.
+func (rb *RingBuffer[T]) WaitForWriteSeq(seq uint64, timeout time.Duration) error {
+	return rb.waitCursor(&rb.write, seq, timeout)
+}
+
+// WaitForReadSeq is WaitForWriteSeq for consumers: it blocks until they
+// have claimed at least seq items in total, e.g. for a producer to know
+// consumers caught up with a checkpoint.
+func (rb *RingBuffer[T]) WaitForReadSeq(seq uint64, timeout time.Duration) error {
+	return rb.waitCursor(&rb.read, seq, timeout)
+}
+
+func (rb *RingBuffer[T]) waitCursor(cursor *uint64, seq uint64, timeout time.Duration) error {
+	var start time.Time
+	if timeout > 0 {
+		start = time.Now()
+	}
+	for int64(atomic.LoadUint64(cursor)-seq) < 0 {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return rb.disposedErr()
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return ErrTimeout
+		}
+		rb.spin()
+	}
+	return nil
 }
 
 // Get will return the next item in the queue.  This call will block
 // if the queue is empty.  This call will unblock when an item is added
 // to the queue or Dispose is called on the queue.  An error will be returned
 // if the queue is disposed.
-func (rb *RingBuffer) Get() (interface{}, error) {
+func (rb *RingBuffer[T]) Get() (T, error) {
 	return rb.Poll(0)
 }
 
@@ -98,50 +596,184 @@ func (rb *RingBuffer) Get() (interface{}, error) {
 // to the queue, Dispose is called on the queue, or the timeout is reached. An
 // error will be returned if the queue is disposed or a timeout occurs. A
 // non-positive timeout will block indefinitely.
-func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
+func (rb *RingBuffer[T]) Poll(timeout time.Duration) (T, error) {
+	return rb.poll(nil, deadlineAfter(timeout))
+}
+
+// PollDeadline is Poll with an absolute deadline instead of a timeout, for
+// callers propagating one.  A zero deadline will block indefinitely.
+func (rb *RingBuffer[T]) PollDeadline(deadline time.Time) (T, error) {
+	return rb.poll(nil, deadline)
+}
+
+// deadlineAfter returns the deadline timeout from now, or the zero time for
+// a non-positive timeout.
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// ctxCheckEvery is how many spins GetContext and PutContext do between
+// checks of their context.
+const ctxCheckEvery = 16
+
+// GetContext is Get unblocking when ctx is done too, returning ctx.Err().
+// Cancellation is best-effort: the context is only checked every few spins
+// of the wait loop, and not at all if an item is ready right away.
+func (rb *RingBuffer[T]) GetContext(ctx context.Context) (T, error) {
+	return rb.poll(ctx, time.Time{})
+}
+
+// PutContext is Put unblocking when ctx is done too, returning ctx.Err().
+// Cancellation is best-effort: the context is only checked every few spins
+// of the wait loop, and not at all if there is room right away, so the item
+// may have been enqueued even though ctx was cancelled before the call
+// returned.
+func (rb *RingBuffer[T]) PutContext(ctx context.Context, item T) error {
+	done := ctx.Done()
+	ok, err := rb.put(item, false, func(attempt int) bool {
+		if attempt%ctxCheckEvery == 0 {
+			select {
+			case <-done:
+				return false
+			default:
+			}
+		}
+		rb.spin()
+		return true
+	})
+	if err == nil && !ok {
+		return ctx.Err()
+	}
+	return err
+}
+
+// poll is PollDeadline also unblocking, if ctx is non-nil, when ctx is
+// done, returning ctx.Err().
+func (rb *RingBuffer[T]) poll(ctx context.Context, deadline time.Time) (T, error) {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	if rb.tracing && atomic.LoadUint64(&rb.read) == atomic.LoadUint64(&rb.write) {
+		defer trace.StartRegion(context.Background(), "mpmc.get-blocked-empty").End()
+	}
+	if rb.parking != nil {
+		return rb.pollParked(ctx, deadline)
+	}
+
 	var (
-		n     *node
+		n     *node[T]
+		zero  T
 		pos   = atomic.LoadUint64(&rb.read)
-		start time.Time
+		spins uint64
 	)
-	if timeout > 0 {
-		start = time.Now()
-	}
 L:
 	for {
 		if atomic.LoadUint64(&rb.disposed) == 1 {
-			return nil, errors.New(`queue: closed`)
+			return zero, rb.disposedErr()
 		}
 
-		n = &rb.nodes[pos&rb.mask]
+		n = rb.node(pos)
 		seq := atomic.LoadUint64(&n.position)
+		if rb.yield != nil {
+			rb.yield("get-loaded")
+		}
 		switch dif := seq - (pos + 1); {
 		case dif == 0:
 			if atomic.CompareAndSwapUint64(&rb.read, pos, pos+1) {
+				if rb.yield != nil {
+					rb.yield("get-claimed")
+				}
 				break L
 			}
+			rb.contended(pos)
+			if rb.yield != nil {
+				rb.yield("get-retry")
+			}
 		case dif < 0:
 			panic(`Ring buffer in compromised state during a get operation.`)
 		default:
+			// An empty slot at the read cursor means the ring is drained,
+			// so anything spilled to the overflow is next in line.
+			if seq == pos && rb.overflow != nil {
+				if data, ok := rb.overflow.pop(); ok {
+					return data, nil
+				}
+			}
 			pos = atomic.LoadUint64(&rb.read)
+			if rb.yield != nil {
+				rb.yield("get-retry")
+			}
 		}
 
-		if timeout > 0 && time.Since(start) >= timeout {
-			return nil, errors.New(`queue: poll timed out`)
+		spins++
+		if !deadline.IsZero() && spins%rb.timeoutCheckEvery == 0 && !time.Now().Before(deadline) {
+			return zero, ErrTimeout
+		}
+		if done != nil && spins%ctxCheckEvery == 0 {
+			select {
+			case <-done:
+				return zero, ctx.Err()
+			default:
+			}
 		}
 
-		runtime.Gosched() // free up the cpu before the next iteration
+		rb.spin()
 	}
 	data := n.data
+	rb.clear(n)
 	atomic.StoreUint64(&n.position, pos+rb.mask+1) // cache coherence traffic
+	if rb.buried(pos) {
+		return zero, rb.disposedErr()
+	}
 	return data, nil
 }
 
+// TryGet returns the next item in the queue if there is one, without
+// blocking.  If the queue is empty, this call will return false.  An error
+// will be returned if the queue is disposed.
+func (rb *RingBuffer[T]) TryGet() (T, bool, error) {
+	var zero T
+	pos := atomic.LoadUint64(&rb.read)
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return zero, false, rb.disposedErr()
+		}
+
+		n := rb.node(pos)
+		seq := atomic.LoadUint64(&n.position)
+		switch seq {
+		case pos + 1:
+			if atomic.CompareAndSwapUint64(&rb.read, pos, pos+1) {
+				data := n.data
+				rb.clear(n)
+				atomic.StoreUint64(&n.position, pos+rb.mask+1) // cache coherence traffic
+				if rb.buried(pos) {
+					return zero, false, rb.disposedErr()
+				}
+				return data, true, nil
+			}
+			rb.contended(pos)
+		case pos:
+			if rb.overflow != nil {
+				if data, ok := rb.overflow.pop(); ok {
+					return data, true, nil
+				}
+			}
+			return zero, false, nil
+		}
+		pos = atomic.LoadUint64(&rb.read)
+	}
+}
+
 // Put adds the provided item to the queue.  If the queue is full, this
 // call will block until an item is added to the queue or Dispose is called
 // on the queue.  An error will be returned if the queue is disposed.
-func (rb *RingBuffer) Put(item interface{}) error {
-	_, err := rb.put(item, false)
+func (rb *RingBuffer[T]) Put(item T) error {
+	_, err := rb.put(item, false, nil)
 	return err
 }
 
@@ -150,39 +782,156 @@ func (rb *RingBuffer) Put(item interface{}) error {
 // queue is disposed.
 //
 // WARNING: not guaranteed to be full when multiple producers try to put concurrently!
-func (rb *RingBuffer) Offer(item interface{}) (bool, error) {
-	return rb.put(item, true)
+func (rb *RingBuffer[T]) Offer(item T) (bool, error) {
+	return rb.put(item, true, nil)
+}
+
+// OfferAll offers the provided items to the queue in order, stopping at the
+// first one that does not fit.  It returns the number of items accepted, so
+// items[accepted:] can be retried later.  An error will be returned if the
+// queue is disposed.
+func (rb *RingBuffer[T]) OfferAll(items []T) (int, error) {
+	for i, item := range items {
+		ok, err := rb.put(item, true, nil)
+		if err != nil {
+			return i, err
+		}
+		if !ok {
+			return i, nil
+		}
+	}
+	return len(items), nil
+}
+
+// OfferBatch is OfferAll reporting, for each of the provided items, whether
+// it was accepted.  The accepted items are a prefix of items, as OfferBatch
+// stops at the first rejection so that retried items don't end up behind
+// later ones.
+func (rb *RingBuffer[T]) OfferBatch(items []T) ([]bool, error) {
+	accepted := make([]bool, len(items))
+	n, err := rb.OfferAll(items)
+	for i := 0; i < n; i++ {
+		accepted[i] = true
+	}
+	return accepted, err
+}
+
+// PutWith adds the provided item to the queue.  If the queue is full, backoff
+// is called with the number of failed attempts so far, starting at 1, in
+// place of the default yield.  Returning true retries the put, returning
+// false gives up and ErrTimeout is returned.  This lets callers plug in
+// any waiting policy.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer[T]) PutWith(item T, backoff func(attempt int) bool) error {
+	ok, err := rb.put(item, false, backoff)
+	if err == nil && !ok {
+		return ErrTimeout
+	}
+	return err
+}
+
+// offerForCheckEvery is how many spins OfferFor does between clock reads.
+const offerForCheckEvery = 16
+
+// OfferFor adds the provided item to the queue, busy-spinning for up to
+// budget while the queue is full.  Unlike Put it never yields the processor,
+// which suits sub-microsecond waits where rescheduling the goroutine would
+// cost more than the wait itself.  The clock is only read every few spins,
+// so the budget may be overrun slightly.  If the queue is still full after
+// budget, this call will return false.  An error will be returned if the
+// queue is disposed.
+func (rb *RingBuffer[T]) OfferFor(item T, budget time.Duration) (bool, error) {
+	start := time.Now()
+	return rb.put(item, false, func(attempt int) bool {
+		return attempt%offerForCheckEvery != 0 || time.Since(start) < budget
+	})
+}
+
+func (rb *RingBuffer[T]) put(item T, offer bool, backoff func(attempt int) bool) (bool, error) {
+	var (
+		ok  bool
+		err error
+	)
+	if rb.tracing && atomic.LoadUint64(&rb.write)-atomic.LoadUint64(&rb.read) >= rb.Cap() {
+		defer trace.StartRegion(context.Background(), "mpmc.put-blocked-full").End()
+	}
+	if rb.overflow != nil {
+		err = rb.spill(item)
+		ok = err == nil
+	} else {
+		ok, err = rb.enqueue(item, offer, backoff)
+	}
+	if ok && rb.occupancy != nil {
+		rb.recordOccupancy()
+	}
+	if rb.metrics != nil {
+		rb.recordMetrics(ok, err)
+	}
+	if ok && rb.parking != nil {
+		rb.parking.signal()
+	}
+	return ok, err
 }
 
-func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
-	var n *node
-	pos := atomic.LoadUint64(&rb.write)
+func (rb *RingBuffer[T]) enqueue(item T, offer bool, backoff func(attempt int) bool) (bool, error) {
+	var (
+		n       *node[T]
+		attempt int
+		pos     = atomic.LoadUint64(&rb.write)
+	)
 L:
 	for {
 		if atomic.LoadUint64(&rb.disposed) == 1 {
-			return false, errors.New(`queue: closed`)
+			return false, rb.disposedErr()
 		}
 
-		n = &rb.nodes[pos&rb.mask]
+		n = rb.node(pos)
 		seq := atomic.LoadUint64(&n.position)
+		if rb.yield != nil {
+			rb.yield("put-loaded")
+		}
 		switch dif := seq - pos; {
 		case dif == 0:
 			if atomic.CompareAndSwapUint64(&rb.write, pos, pos+1) {
+				if rb.yield != nil {
+					rb.yield("put-claimed")
+				}
 				break L
 			}
+			rb.contended(pos)
+			if rb.yield != nil {
+				rb.yield("put-retry")
+			}
 		case dif < 0:
 			panic(`Ring buffer in a compromised state during a put operation.`)
 		default:
 			pos = atomic.LoadUint64(&rb.write)
+			if rb.yield != nil {
+				rb.yield("put-retry")
+			}
 		}
 
 		if offer {
 			return false, nil
 		}
+		if backoff != nil {
+			attempt++
+			if !backoff(attempt) {
+				return false, nil
+			}
+			continue
+		}
 
-		runtime.Gosched() // free up the cpu before the next iteration
+		rb.spin()
 	}
 
+	// A Dispose since the check above may have been followed by a drain
+	// that found this slot claimed.  The drain waits for it to be
+	// published, and finds the item unless the slot is buried.
+	if atomic.LoadUint64(&rb.disposed) == 1 {
+		rb.bury(pos)
+		atomic.StoreUint64(&n.position, pos+1) // cache coherence traffic
+		return false, rb.disposedErr()
+	}
 	n.data = item
 	atomic.StoreUint64(&n.position, pos+1) // cache coherence traffic
 	return true, nil
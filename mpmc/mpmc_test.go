@@ -1,7 +1,20 @@
 package mpmc
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"runtime/trace"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func BenchmarkChannel(b *testing.B) {
@@ -20,7 +33,7 @@ func BenchmarkChannel(b *testing.B) {
 }
 
 func BenchmarkMPMC(b *testing.B) {
-	q := NewRingBuffer(8192)
+	q := NewRingBuffer[interface{}](8192)
 
 	b.ResetTimer()
 	go func() {
@@ -55,7 +68,7 @@ func BenchmarkChannelConcurrentWrite(b *testing.B) {
 }
 
 func BenchmarkMPMCConcurrentWrite(b *testing.B) {
-	q := NewRingBuffer(8192)
+	q := NewRingBuffer[interface{}](8192)
 	// numGr := runtime.GOMAXPROCS(0)
 
 	b.ResetTimer()
@@ -73,3 +86,1459 @@ func BenchmarkMPMCConcurrentWrite(b *testing.B) {
 		}
 	})
 }
+
+func TestNodeContentionDisabled(t *testing.T) {
+	q := NewRingBuffer[interface{}](8)
+	if c := q.NodeContention(); c != nil {
+		t.Fatalf("expected nil contention, got %v", c)
+	}
+}
+
+func TestNodeContention(t *testing.T) {
+	const numProducers, numItems = 4, 10_000
+	q := NewRingBuffer[interface{}](8, WithContentionTracking())
+
+	var wg sync.WaitGroup
+	for p := 0; p < numProducers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numItems; i++ {
+				q.Put(i)
+			}
+		}()
+	}
+	for i := 0; i < numProducers*numItems; i++ {
+		if _, err := q.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	c := q.NodeContention()
+	if uint64(len(c)) != q.Cap() {
+		t.Fatalf("expected %d counters, got %d", q.Cap(), len(c))
+	}
+	t.Log("contention per node:", c)
+}
+
+func BenchmarkMPMCNodeContention(b *testing.B) {
+	q := NewRingBuffer[interface{}](8192, WithContentionTracking())
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.Put(`a`)
+		}
+	})
+	b.StopTimer()
+
+	var total, max uint64
+	for _, n := range q.NodeContention() {
+		total += n
+		if n > max {
+			max = n
+		}
+	}
+	b.ReportMetric(float64(total)/float64(q.Cap()), "cas-fails/node")
+	b.ReportMetric(float64(max), "max-cas-fails")
+}
+
+func TestPutWithGivesUp(t *testing.T) {
+	q := NewRingBuffer[interface{}](4)
+	for i := uint64(0); i < q.Cap(); i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls int
+	err := q.PutWith(`a`, func(attempt int) bool {
+		calls++
+		if attempt != calls {
+			t.Fatalf("expected attempt %d, got %d", calls, attempt)
+		}
+		return attempt < 10
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected put to give up on a full queue with %v, got %v", ErrTimeout, err)
+	}
+	if calls != 10 {
+		t.Fatalf("expected 10 backoff calls, got %d", calls)
+	}
+}
+
+func TestParallelInit(t *testing.T) {
+	q := NewRingBuffer[interface{}](parallelInitThreshold)
+	for i := range q.nodes {
+		if q.nodes[i].position != uint64(i) {
+			t.Fatalf("node %d: expected position %d, got %d", i, i, q.nodes[i].position)
+		}
+	}
+}
+
+func BenchmarkInitSequential64M(b *testing.B) {
+	ns := make(nodes[interface{}], 1<<26)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ns.fill(0, 0)
+	}
+}
+
+func BenchmarkInitParallel64M(b *testing.B) {
+	ns := make(nodes[interface{}], 1<<26)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ns.parallelFill(0)
+	}
+}
+
+func TestOverflowBurst(t *testing.T) {
+	const numItems = 100
+	q := NewRingBuffer[interface{}](8, WithOverflow())
+
+	for i := 0; i < numItems; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := q.Overflowed(); n != numItems-q.Cap() {
+		t.Fatalf("expected %d overflowed items, got %d", numItems-q.Cap(), n)
+	}
+	if ok, err := q.Offer(numItems); !ok || err != nil {
+		t.Fatalf("expected offer to spill, got %v, %v", ok, err)
+	}
+
+	for i := 0; i <= numItems; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if n := q.Overflowed(); n != 0 {
+		t.Fatalf("expected empty overflow, got %d", n)
+	}
+}
+
+// checksummed is a payload whose fields must be observed fully written by
+// any consumer that received it.
+type checksummed struct {
+	vals [8]uint64
+	sum  uint64
+}
+
+func newChecksummed(seed uint64) checksummed {
+	var c checksummed
+	for i := range c.vals {
+		seed = seed*6364136223846793005 + 1442695040888963407
+		c.vals[i] = seed
+		c.sum += seed
+	}
+	return c
+}
+
+func (c checksummed) valid() bool {
+	var sum uint64
+	for _, v := range c.vals {
+		sum += v
+	}
+	return sum == c.sum
+}
+
+// TestPublishHappensBefore encodes the publish protocol contract: a producer
+// writes n.data before storing n.position, so a consumer that loads that
+// position must observe the fully written payload. Run it with -race.
+func TestPublishHappensBefore(t *testing.T) {
+	const numProducers, numConsumers = 4, 4
+	numItems := 20_000
+	if testing.Short() {
+		numItems = 1_000
+	}
+	q := NewRingBuffer[interface{}](64)
+
+	var wg sync.WaitGroup
+	for p := 0; p < numProducers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < numItems; i++ {
+				q.Put(newChecksummed(uint64(p*numItems + i)))
+			}
+		}(p)
+	}
+
+	errs := make(chan error, numConsumers)
+	for c := 0; c < numConsumers; c++ {
+		go func() {
+			for i := 0; i < numProducers*numItems/numConsumers; i++ {
+				got, err := q.Get()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !got.(checksummed).valid() {
+					errs <- fmt.Errorf("payload failed its checksum: %+v", got)
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for c := 0; c < numConsumers; c++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestOfferAll(t *testing.T) {
+	q := NewRingBuffer[interface{}](4)
+	items := make([]interface{}, 10)
+	for i := range items {
+		items[i] = i
+	}
+
+	accepted, err := q.OfferAll(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted != 4 {
+		t.Fatalf("expected 4 accepted, got %d", accepted)
+	}
+	for i := 0; i < accepted; i++ {
+		if got, _ := q.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+
+	q.Dispose()
+	if _, err := q.OfferAll(items[accepted:]); err == nil {
+		t.Fatal("expected an error on a disposed queue")
+	}
+}
+
+func TestOfferBatch(t *testing.T) {
+	q := NewRingBuffer[interface{}](4)
+	q.Put(`a`)
+	accepted, err := q.OfferBatch([]interface{}{0, 1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(accepted) != "[true true true false false]" {
+		t.Fatalf("expected the first 3 items accepted, got %v", accepted)
+	}
+	for _, want := range []interface{}{`a`, 0, 1, 2} {
+		if got, _ := q.Get(); got != want {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	q.Dispose()
+	accepted, err = q.OfferBatch([]interface{}{3, 4})
+	if err == nil || fmt.Sprint(accepted) != "[false false]" {
+		t.Fatalf("expected nothing accepted and an error on a disposed queue, got %v, %v", accepted, err)
+	}
+}
+
+func TestParking(t *testing.T) {
+	const numProducers, numConsumers, numItems = 4, 4, 1_000
+	q := NewRingBuffer[interface{}](16, WithParking())
+
+	for p := 0; p < numProducers; p++ {
+		go func() {
+			for i := 0; i < numItems; i++ {
+				q.Put(i)
+				if i%100 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for c := 0; c < numConsumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numProducers*numItems/numConsumers; i++ {
+				if _, err := q.Get(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := q.Poll(time.Millisecond); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+
+	errs := make(chan error)
+	go func() {
+		_, err := q.Get()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Dispose()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error after dispose")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected dispose to wake the parked consumer")
+	}
+}
+
+func cpuTime(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func benchmarkIdleConsumer(b *testing.B, opts ...Option) {
+	const idle = 50 * time.Millisecond
+	var spent time.Duration
+	for i := 0; i < b.N; i++ {
+		q := NewRingBuffer[interface{}](8192, opts...)
+		go q.Get()
+		start := cpuTime(b)
+		time.Sleep(idle)
+		spent += cpuTime(b) - start
+		q.Dispose()
+	}
+	b.ReportMetric(float64(spent)/float64(time.Duration(b.N)*idle), "cpu/idle")
+}
+
+func BenchmarkMPMCIdleConsumerSpinning(b *testing.B) {
+	benchmarkIdleConsumer(b)
+}
+
+func BenchmarkMPMCIdleConsumerParking(b *testing.B) {
+	benchmarkIdleConsumer(b, WithParking())
+}
+
+func BenchmarkMPMCIdleConsumerCPUPause(b *testing.B) {
+	benchmarkIdleConsumer(b, WithCPUPause())
+}
+
+func BenchmarkMPMCParking(b *testing.B) {
+	q := NewRingBuffer[interface{}](8192, WithParking())
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		q.Put(`a`)
+	}
+}
+
+func TestCause(t *testing.T) {
+	errFault := errors.New("fault")
+
+	q := NewRingBuffer[interface{}](4)
+	if err := q.Cause(); err != nil {
+		t.Fatalf("expected nil cause before dispose, got %v", err)
+	}
+	q.DisposeWithError(errFault)
+	q.DisposeWithError(errors.New("later"))
+	if err := q.Cause(); err != errFault {
+		t.Fatalf("expected %v, got %v", errFault, err)
+	}
+	if !q.IsDisposed() {
+		t.Fatal("expected queue to be disposed")
+	}
+
+	q = NewRingBuffer[interface{}](4)
+	q.Dispose()
+	q.DisposeWithError(errFault)
+	if err := q.Cause(); err != nil {
+		t.Fatalf("expected nil cause after a plain dispose, got %v", err)
+	}
+}
+
+func TestOccupancyHistogram(t *testing.T) {
+	q := NewRingBuffer[interface{}](8, WithOccupancyHistogram())
+	for i := 0; i < 8; i++ {
+		q.Put(i)
+	}
+	if h := q.OccupancyHistogram(); h != [4]uint64{1, 2, 2, 3} {
+		t.Fatalf("unexpected histogram filling up: %v", h)
+	}
+
+	// Put into a drained queue.
+	for i := 0; i < 8; i++ {
+		q.Get()
+	}
+	q.Put(8)
+	if h := q.OccupancyHistogram(); h != [4]uint64{2, 2, 2, 3} {
+		t.Fatalf("unexpected histogram after draining: %v", h)
+	}
+
+	if h := NewRingBuffer[interface{}](8).OccupancyHistogram(); h != [4]uint64{} {
+		t.Fatalf("expected an empty histogram when disabled, got %v", h)
+	}
+}
+
+func TestLen(t *testing.T) {
+	q := NewRingBuffer[int](4)
+	for i := 0; i < 4; i++ {
+		if l := q.Len(); l != uint64(i) {
+			t.Fatalf("expected length %d, got %d", i, l)
+		}
+		q.Put(i)
+	}
+	q.Get()
+	if l := q.Len(); l != 3 {
+		t.Fatalf("expected length 3, got %d", l)
+	}
+
+	// Snapshots are racy under concurrent puts and gets, but stay usable.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				q.Put(j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				q.Get()
+			}
+		}()
+	}
+	var max uint64
+	for i := 0; i < 10000; i++ {
+		if l := q.Len(); l > max {
+			max = l
+		}
+	}
+	wg.Wait()
+	t.Logf("longest length seen: %d", max)
+	if l := q.Len(); l != 3 {
+		t.Fatalf("expected length 3 once done, got %d", l)
+	}
+}
+
+func TestDrainDispose(t *testing.T) {
+	q := NewRingBuffer[interface{}](8)
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	q.Get()
+
+	items := q.DrainDispose()
+	if len(items) != 4 {
+		t.Fatalf("expected 4 items, got %v", items)
+	}
+	for i, item := range items {
+		if item != i+1 {
+			t.Fatalf("expected %d, got %v", i+1, item)
+		}
+	}
+	if !q.IsDisposed() {
+		t.Fatal("expected queue to be disposed")
+	}
+	if err := q.Put(5); err == nil {
+		t.Fatal("expected an error from Put after dispose")
+	}
+}
+
+func TestOfferFor(t *testing.T) {
+	q := NewRingBuffer[interface{}](2)
+	for i := 0; i < 2; i++ {
+		if ok, err := q.OfferFor(i, time.Millisecond); !ok || err != nil {
+			t.Fatalf("expected offer to succeed, got %v, %v", ok, err)
+		}
+	}
+
+	start := time.Now()
+	if ok, err := q.OfferFor(2, time.Millisecond); ok || err != nil {
+		t.Fatalf("expected offer on a full queue to give up, got %v, %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond {
+		t.Fatalf("expected offer to spin for its budget, gave up after %v", elapsed)
+	}
+
+	q.Dispose()
+	if _, err := q.OfferFor(2, time.Millisecond); err == nil {
+		t.Fatal("expected an error after dispose")
+	}
+}
+
+// benchmarkBrieflyFull measures enqueuing into a small queue that is full
+// most of the time, drained by a consumer that keeps up on average.
+func benchmarkBrieflyFull(b *testing.B, put func(q *RingBuffer[interface{}], item interface{})) {
+	q := NewRingBuffer[interface{}](4)
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		put(q, `a`)
+	}
+}
+
+func BenchmarkBrieflyFullPut(b *testing.B) {
+	benchmarkBrieflyFull(b, func(q *RingBuffer[interface{}], item interface{}) {
+		q.Put(item)
+	})
+}
+
+func BenchmarkBrieflyFullOfferFor(b *testing.B) {
+	benchmarkBrieflyFull(b, func(q *RingBuffer[interface{}], item interface{}) {
+		// Fall back to yielding once the spin budget is spent.
+		if ok, _ := q.OfferFor(item, time.Microsecond); !ok {
+			q.Put(item)
+		}
+	})
+}
+
+func TestOnDisposeOnce(t *testing.T) {
+	var calls int32
+	q := NewRingBuffer[interface{}](4, WithOnDispose(func() {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				q.Dispose()
+			} else {
+				q.DisposeWithError(errors.New("fault"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected the callback to run once, ran %d times", calls)
+	}
+}
+
+func TestPollTimeoutCheckInterval(t *testing.T) {
+	for _, every := range []uint64{1, defaultTimeoutCheckEvery, 1024} {
+		q := NewRingBuffer[interface{}](4, WithTimeoutCheckInterval(every))
+		start := time.Now()
+		if _, err := q.Poll(time.Millisecond); err == nil {
+			t.Fatal("expected poll on an empty queue to time out")
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond || elapsed > 100*time.Millisecond {
+			t.Fatalf("checking every %d spins: timed out after %v", every, elapsed)
+		}
+	}
+}
+
+func TestPollDeadline(t *testing.T) {
+	q := NewRingBuffer[int](4)
+	start := time.Now()
+	if _, err := q.PollDeadline(start.Add(5 * time.Millisecond)); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond || elapsed > 100*time.Millisecond {
+		t.Fatalf("timed out after %v", elapsed)
+	}
+	if _, err := q.PollDeadline(start); err == nil {
+		t.Fatal("expected a past deadline to time out")
+	}
+
+	// A zero deadline blocks until there is an item.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(1)
+	}()
+	if item, err := q.PollDeadline(time.Time{}); item != 1 || err != nil {
+		t.Fatalf("expected 1, got %v, %v", item, err)
+	}
+}
+
+func benchmarkEmptyPoll(b *testing.B, every uint64) {
+	q := NewRingBuffer[interface{}](4, WithTimeoutCheckInterval(every))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Poll(10 * time.Microsecond)
+	}
+}
+
+func BenchmarkEmptyPollCheckEverySpin(b *testing.B) {
+	benchmarkEmptyPoll(b, 1)
+}
+
+func BenchmarkEmptyPollCheckEvery64Spins(b *testing.B) {
+	benchmarkEmptyPoll(b, defaultTimeoutCheckEvery)
+}
+
+func TestWorkerPool(t *testing.T) {
+	const numWorkers, numItems = 4, 10_000
+	handled := make([]int32, numItems)
+	wp := NewWorkerPool(64, numWorkers, func(i int) error {
+		atomic.AddInt32(&handled[i], 1)
+		if i%1000 == 0 {
+			return fmt.Errorf("item %d", i)
+		}
+		return nil
+	})
+
+	for i := 0; i < numItems; i++ {
+		if err := wp.Submit(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := wp.Shutdown()
+
+	for i, n := range handled {
+		if n != 1 {
+			t.Fatalf("item %d handled %d times", i, n)
+		}
+	}
+	errs, ok := err.(HandlerErrors)
+	if !ok || len(errs) != numItems/1000 {
+		t.Fatalf("expected %d handler errors, got %v", numItems/1000, err)
+	}
+	if err := wp.Submit(0); !errors.Is(err, ErrShutDown) {
+		t.Fatalf("expected submit to fail after shutdown with %v, got %v", ErrShutDown, err)
+	}
+}
+
+func TestTracingRegions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skip("tracing unavailable:", err)
+	}
+
+	q := NewRingBuffer[interface{}](2, WithTracing())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(0)
+		q.Put(1)
+		q.Put(2)
+	}()
+	for i := 0; i < 3; i++ {
+		q.Get()
+		time.Sleep(10 * time.Millisecond)
+	}
+	trace.Stop()
+
+	for _, region := range []string{"mpmc.get-blocked-empty", "mpmc.put-blocked-full"} {
+		if !bytes.Contains(buf.Bytes(), []byte(region)) {
+			t.Errorf("expected a %s region in the trace", region)
+		}
+	}
+}
+
+// TestNodeSize documents the size of a node on 64-bit platforms: a uint64 and
+// an interface{}, so nodes don't line up with 64 byte cache lines and some
+// straddle two.  NewRingBufferPaddedNodes pads them.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node[interface{}]{}); size != 24 {
+		t.Fatalf("expected a 24 byte node, got %d", size)
+	}
+}
+
+func TestPaddedNodes(t *testing.T) {
+	q := NewRingBufferPaddedNodes[interface{}](8)
+	if size := unsafe.Sizeof(node[interface{}]{}) << q.spread; size%cacheLine != 0 {
+		t.Fatalf("expected slots to be a multiple of %d bytes, got %d", cacheLine, size)
+	}
+	if q.Cap() != 8 {
+		t.Fatalf("expected a capacity of 8, got %d", q.Cap())
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 8; i++ {
+			if ok, _ := q.Offer(i); !ok {
+				t.Fatalf("round %d: expected offer %d to succeed", round, i)
+			}
+		}
+		if ok, _ := q.Offer(8); ok {
+			t.Fatalf("round %d: expected offer on a full queue to fail", round)
+		}
+		for i := 0; i < 8; i++ {
+			if got, _ := q.Get(); got != i {
+				t.Fatalf("round %d: expected %d, got %v", round, i, got)
+			}
+		}
+	}
+}
+
+// BenchmarkMPMCPaddedNodes pits padded against packed nodes with every
+// goroutine both producing and consuming, so adjacent slots are worked on
+// concurrently.
+func BenchmarkMPMCPaddedNodes(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		new  func(uint64, ...Option) *RingBuffer[interface{}]
+	}{
+		{"packed", NewRingBuffer[interface{}]},
+		{"padded", NewRingBufferPaddedNodes[interface{}]},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			q := bc.new(8192)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					q.Put(`a`)
+					q.Get()
+				}
+			})
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+	for _, padded := range []bool{false, true} {
+		q := NewRingBuffer[interface{}](4, WithParking())
+		if padded {
+			q = NewRingBufferPaddedNodes[interface{}](4, WithParking())
+		}
+		q.Put(1)
+		q.Put(2)
+		q.Get()
+		q.DisposeWithError(errors.New("fault"))
+
+		q.Restart()
+		if q.IsDisposed() || q.Cause() != nil {
+			t.Fatal("expected a restarted queue not to be disposed")
+		}
+		if _, err := q.Poll(time.Millisecond); err == nil {
+			t.Fatal("expected a restarted queue to be empty")
+		}
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 4; i++ {
+				if ok, _ := q.Offer(i); !ok {
+					t.Fatalf("round %d: expected offer %d to succeed", round, i)
+				}
+			}
+			for i := 0; i < 4; i++ {
+				if got, _ := q.Get(); got != i {
+					t.Fatalf("round %d: expected %d, got %v", round, i, got)
+				}
+			}
+		}
+
+		// It can be disposed, and restarted, again.
+		q.Dispose()
+		if err := q.Put(0); err == nil {
+			t.Fatal("expected put on a disposed queue to fail")
+		}
+		q.Restart()
+		if err := q.Put(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer[interface{}](64)
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.read >= uint64(ops) {
+		t.Fatalf("expected the read sequence to have wrapped around, got %d", q.read)
+	}
+}
+
+func TestDisposeError(t *testing.T) {
+	errStop := errors.New("stopped")
+	q := NewRingBuffer[interface{}](4, WithDisposeError(errStop))
+	q.Dispose()
+	if _, err := q.Get(); !errors.Is(err, errStop) {
+		t.Fatalf("expected Get to return %v, got %v", errStop, err)
+	}
+	if _, err := q.Poll(time.Millisecond); !errors.Is(err, errStop) {
+		t.Fatalf("expected Poll to return %v, got %v", errStop, err)
+	}
+	if err := q.Put(1); !errors.Is(err, errStop) {
+		t.Fatalf("expected Put to return %v, got %v", errStop, err)
+	}
+
+	q = NewRingBuffer[interface{}](4, WithDisposeError(io.EOF))
+	q.Dispose()
+	if _, err := q.Get(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	q := NewRingBuffer[interface{}](4, WithMetrics())
+	for i := 0; i < 4; i++ {
+		q.Put(i)
+	}
+	q.Offer(4)
+	q.Offer(5)
+	q.Get()
+	q.Get()
+	q.Get()
+	q.Put(6)
+
+	want := Metrics{
+		Capacity:      4,
+		ApproxLen:     2,
+		TotalPuts:     5,
+		TotalGets:     3,
+		DroppedOffers: 2,
+		HighWater:     4,
+	}
+	if m := q.Metrics(); m != want {
+		t.Fatalf("expected %+v, got %+v", want, m)
+	}
+
+	q = NewRingBuffer[interface{}](2, WithOverflow())
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	q.Get()
+	q.Get()
+	q.Get()
+	m := q.Metrics()
+	if m.TotalPuts != 5 || m.TotalGets != 3 || m.ApproxLen != 2 {
+		t.Fatalf("expected 5 puts, 3 gets and 2 items with overflow, got %+v", m)
+	}
+	if m.DroppedOffers != 0 || m.HighWater != 0 {
+		t.Fatalf("expected no dropped offers or high water without WithMetrics, got %+v", m)
+	}
+}
+
+func TestActiveProducers(t *testing.T) {
+	q := NewRingBuffer[interface{}](64)
+	const producers = 8
+	var (
+		start, wg sync.WaitGroup
+		last      int32
+	)
+	start.Add(producers)
+	wg.Add(producers)
+	for i := 0; i < producers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			q.RegisterProducer()
+			start.Done()
+			start.Wait()
+			q.Put(i)
+			if q.UnregisterProducer() == 0 {
+				atomic.AddInt32(&last, 1)
+			}
+		}(i)
+	}
+	start.Wait()
+	if n := q.ActiveProducers(); n > producers {
+		t.Fatalf("expected at most %d active producers, got %d", producers, n)
+	}
+	wg.Wait()
+	if n := q.ActiveProducers(); n != 0 {
+		t.Fatalf("expected no active producers, got %d", n)
+	}
+	if last != 1 {
+		t.Fatalf("expected exactly one producer to see the count reach 0, got %d", last)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected unregistering with no producer to panic")
+		}
+		if n := q.ActiveProducers(); n != 0 {
+			t.Fatalf("expected a failed unregister to leave the count at 0, got %d", n)
+		}
+	}()
+	q.UnregisterProducer()
+}
+
+// benchmarkPingPong measures the round trip of an item sent to an otherwise
+// idle consumer and echoed back.
+func benchmarkPingPong(b *testing.B, opts ...Option) {
+	ping := NewRingBuffer[interface{}](2, opts...)
+	pong := NewRingBuffer[interface{}](2, opts...)
+	go func() {
+		for {
+			item, err := ping.Get()
+			if err != nil {
+				return
+			}
+			pong.Put(item)
+		}
+	}()
+	defer ping.Dispose()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ping.Put(i)
+		pong.Get()
+	}
+}
+
+func BenchmarkPingPongGosched(b *testing.B) {
+	benchmarkPingPong(b)
+}
+
+func BenchmarkPingPongPureSpin(b *testing.B) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		b.Skip("pure spin needs a core per goroutine")
+	}
+	benchmarkPingPong(b, WithPureSpin())
+}
+
+func BenchmarkPingPongCPUPause(b *testing.B) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		b.Skip("pure spin needs a core per goroutine")
+	}
+	benchmarkPingPong(b, WithCPUPause())
+}
+
+func TestPureSpin(t *testing.T) {
+	for _, opt := range []Option{WithPureSpin(), WithCPUPause()} {
+		q := NewRingBuffer[interface{}](2, opt)
+		for i := 0; i < 5; i++ {
+			q.Put(i)
+			if item, err := q.Get(); item != i || err != nil {
+				t.Fatalf("expected %d, got %v, %v", i, item, err)
+			}
+		}
+		if _, err := q.Poll(time.Millisecond); err == nil {
+			t.Fatal("expected poll on an empty queue to time out")
+		}
+	}
+}
+
+func TestSourceFromSlice(t *testing.T) {
+	const n = 10000
+	items := make([]int, n)
+	for i := range items {
+		items[i] = i
+	}
+	s := NewSourceFromSlice(items)
+	if r := s.Remaining(); r != n {
+		t.Fatalf("expected %d remaining items, got %d", n, r)
+	}
+
+	const consumers = 4
+	seen := make([]int32, n)
+	var wg sync.WaitGroup
+	wg.Add(consumers)
+	for c := 0; c < consumers; c++ {
+		go func() {
+			defer wg.Done()
+			for {
+				item, err := s.Get()
+				if err == ErrExhausted {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				atomic.AddInt32(&seen[item], 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, count := range seen {
+		if count != 1 {
+			t.Fatalf("expected item %d to be consumed once, got %d", i, count)
+		}
+	}
+	if r := s.Remaining(); r != 0 {
+		t.Fatalf("expected no remaining items, got %d", r)
+	}
+	if _, err := s.Get(); err != ErrExhausted {
+		t.Fatalf("expected ErrExhausted, got %v", err)
+	}
+}
+
+func TestSeekRead(t *testing.T) {
+	q := NewRingBuffer[interface{}](8, WithReplay())
+	for i := 0; i < 6; i++ {
+		q.Put(i)
+	}
+	for i := 0; i < 4; i++ {
+		q.Get()
+	}
+
+	// Replay from 1, within the buffered window.
+	if err := q.SeekRead(1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 6; i++ {
+		if item, err := q.Get(); item != i || err != nil {
+			t.Fatalf("expected %d, got %v, %v", i, item, err)
+		}
+	}
+
+	// Puts wrap around and reuse the slots of 0 to 3.
+	for i := 6; i < 12; i++ {
+		q.Put(i)
+	}
+	for _, seq := range []uint64{3, 13} {
+		if err := q.SeekRead(seq); err != ErrSeekOutOfRange {
+			t.Fatalf("expected ErrSeekOutOfRange seeking to %d, got %v", seq, err)
+		}
+	}
+	if err := q.SeekRead(4); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := q.Get(); item != 4 {
+		t.Fatalf("expected 4, got %v", item)
+	}
+
+	// Seek forward, skipping items, then up to the write cursor.
+	if err := q.SeekRead(10); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := q.Get(); item != 10 {
+		t.Fatalf("expected 10, got %v", item)
+	}
+	if err := q.SeekRead(12); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := q.Offer(12); !ok {
+		t.Fatal("expected room after skipping every item")
+	}
+	if item, _ := q.Get(); item != 12 {
+		t.Fatalf("expected 12, got %v", item)
+	}
+
+	// Without WithReplay consumed items are gone.
+	q = NewRingBuffer[interface{}](8)
+	q.Put(0)
+	q.Get()
+	if err := q.SeekRead(0); err != ErrSeekOutOfRange {
+		t.Fatalf("expected ErrSeekOutOfRange seeking backward, got %v", err)
+	}
+}
+
+// TestConsumedItemsCollected checks the queue drops its reference to the
+// items it hands out, so they can be garbage collected.
+func TestConsumedItemsCollected(t *testing.T) {
+	for _, get := range []struct {
+		name string
+		get  func(q *RingBuffer[*[1 << 10]byte])
+	}{
+		{"Get", func(q *RingBuffer[*[1 << 10]byte]) { q.Get() }},
+		{"TryGet", func(q *RingBuffer[*[1 << 10]byte]) { q.TryGet() }},
+		{"DrainDispose", func(q *RingBuffer[*[1 << 10]byte]) { q.DrainDispose() }},
+	} {
+		t.Run(get.name, func(t *testing.T) {
+			q := NewRingBuffer[*[1 << 10]byte](4)
+			collected := make(chan struct{})
+			func() {
+				item := new([1 << 10]byte)
+				runtime.SetFinalizer(item, func(*[1 << 10]byte) { close(collected) })
+				q.Put(item)
+			}()
+			get.get(q)
+
+			deadline := time.After(5 * time.Second)
+			for {
+				runtime.GC()
+				select {
+				case <-collected:
+					runtime.KeepAlive(q)
+					return
+				case <-deadline:
+					t.Fatal("expected the consumed item to be garbage collected")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		})
+	}
+}
+
+// TestOfferDisposeRace hammers Offer while the queue is drained and
+// disposed: every item an Offer reported as enqueued must come out exactly
+// once, either to the consumer before the dispose or in the drain, and no
+// item may be published once the drain is done.
+func TestOfferDisposeRace(t *testing.T) {
+	const (
+		rounds    = 200
+		producers = 4
+	)
+	for r := 0; r < rounds; r++ {
+		q := NewRingBuffer[interface{}](8)
+		var (
+			wg       sync.WaitGroup
+			accepted [producers][]int
+			consumed []interface{}
+			done     = make(chan struct{})
+		)
+		wg.Add(producers)
+		for p := 0; p < producers; p++ {
+			go func(p int) {
+				defer wg.Done()
+				for i := p; ; i += producers {
+					ok, err := q.Offer(i)
+					if err != nil {
+						return
+					}
+					if ok {
+						accepted[p] = append(accepted[p], i)
+					}
+					runtime.Gosched()
+				}
+			}(p)
+		}
+		go func() {
+			defer close(done)
+			for {
+				item, err := q.Get()
+				if err != nil {
+					return
+				}
+				consumed = append(consumed, item)
+			}
+		}()
+		for i := 0; i < r%10; i++ {
+			runtime.Gosched()
+		}
+		drained := q.DrainDispose()
+		wg.Wait()
+		<-done
+
+		seen := make(map[interface{}]int)
+		for _, item := range append(consumed, drained...) {
+			seen[item]++
+		}
+		for p := range accepted {
+			for _, i := range accepted[p] {
+				if seen[i] != 1 {
+					t.Fatalf("round %d: expected accepted item %d to come out once, got %d times", r, i, seen[i])
+				}
+				delete(seen, i)
+			}
+		}
+		if len(seen) > 0 {
+			t.Fatalf("round %d: expected only accepted items to come out, got %v", r, seen)
+		}
+		if rd, wr := atomic.LoadUint64(&q.read), atomic.LoadUint64(&q.write); rd != wr {
+			t.Fatalf("round %d: expected the drain to leave read at write, got %d and %d", r, rd, wr)
+		}
+	}
+}
+
+// TestOfferClaimedDuringDispose pauses an Offer right after it claims its
+// slot, the window the stress test above rarely hits, and disposes the
+// queue then.
+func TestOfferClaimedDuringDispose(t *testing.T) {
+	q := NewRingBuffer[interface{}](4)
+	claimed := make(chan struct{})
+	release := make(chan struct{})
+	q.yield = func(step string) {
+		if step == "put-claimed" {
+			close(claimed)
+			<-release
+		}
+	}
+	type result struct {
+		ok  bool
+		err error
+	}
+	offered := make(chan result)
+	go func() {
+		ok, err := q.Offer(1)
+		offered <- result{ok, err}
+	}()
+	<-claimed
+	q.yield = nil
+
+	drained := make(chan []interface{})
+	go func() {
+		drained <- q.DrainDispose()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	res, items := <-offered, <-drained
+	if res.ok != (len(items) == 1) {
+		t.Fatalf("expected the item to be drained if and only if Offer succeeded, got %v, %v and %v", res.ok, res.err, items)
+	}
+	if res.ok || res.err == nil {
+		t.Fatalf("expected an Offer that claimed its slot after the dispose to fail, got %v, %v", res.ok, res.err)
+	}
+	if item, ok, err := q.TryGet(); ok || err == nil {
+		t.Fatalf("expected nothing from the disposed queue, got %v, %v, %v", item, ok, err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	for _, parking := range []bool{false, true} {
+		var opts []Option
+		if parking {
+			opts = append(opts, WithParking())
+		}
+		q := NewRingBuffer[int](2, opts...)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if _, err := q.GetContext(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("parking %v: expected GetContext on an empty queue to time out, got %v", parking, err)
+		}
+		cancel()
+
+		q.Put(0)
+		q.Put(1)
+		ctx, cancel = context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() { errs <- q.PutContext(ctx, 2) }()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		if err := <-errs; err != context.Canceled {
+			t.Fatalf("parking %v: expected PutContext on a full queue to be cancelled, got %v", parking, err)
+		}
+
+		// Calls that can proceed do.
+		if item, err := q.GetContext(context.Background()); item != 0 || err != nil {
+			t.Fatalf("parking %v: expected 0, got %v, %v", parking, item, err)
+		}
+		if err := q.PutContext(context.Background(), 2); err != nil {
+			t.Fatalf("parking %v: %v", parking, err)
+		}
+
+		q.Dispose()
+		if _, err := q.GetContext(context.Background()); err == nil || err == context.Canceled {
+			t.Fatalf("parking %v: expected the disposed error, got %v", parking, err)
+		}
+	}
+}
+
+func TestChannel(t *testing.T) {
+	q := NewRingBuffer[int](8)
+	ch := q.Channel()
+	go func() {
+		for i := 0; i < 20; i++ {
+			q.Put(i)
+		}
+	}()
+	timeout := time.After(time.Second)
+	for i := 0; i < 20; i++ {
+		select {
+		case item := <-ch:
+			if item != i {
+				t.Fatalf("expected %d, got %d", i, item)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for item %d", i)
+		}
+	}
+
+	// Disposing of the queue stops the goroutine, which closes the channel.
+	q.Dispose()
+	select {
+	case item, ok := <-ch:
+		if ok {
+			t.Fatalf("expected the channel closed, got %d", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Dispose to close the channel")
+	}
+}
+
+func TestDrainTo(t *testing.T) {
+	q := NewRingBuffer[int](8)
+	ch := make(chan int, 8)
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		q.DrainTo(ch, done)
+		close(returned)
+	}()
+	q.Put(1)
+	if item := <-ch; item != 1 {
+		t.Fatalf("expected 1, got %d", item)
+	}
+
+	// Closing done stops the drain, leaving the queue usable.
+	close(done)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("expected closing done to stop DrainTo")
+	}
+	q.Put(2)
+	if item, _, _ := q.TryGet(); item != 2 {
+		t.Fatalf("expected 2 left in the queue, got %d", item)
+	}
+}
+
+func TestWaitForSeq(t *testing.T) {
+	q := NewRingBuffer[interface{}](4)
+	go func() {
+		for i := 0; i < 10; i++ {
+			q.Put(i)
+		}
+	}()
+
+	// The consumer waits for the producer to reach its checkpoint, and the
+	// producer for the consumer to catch up.
+	if err := q.WaitForWriteSeq(3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	caught := make(chan error)
+	go func() {
+		caught <- q.WaitForReadSeq(6, time.Second)
+	}()
+	for i := 0; i < 6; i++ {
+		if item, _ := q.Get(); item != i {
+			t.Fatalf("expected %d, got %v", i, item)
+		}
+	}
+	if err := <-caught; err != nil {
+		t.Fatal(err)
+	}
+	for i := 6; i < 10; i++ {
+		q.Get()
+	}
+	if err := q.WaitForWriteSeq(10, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.WaitForWriteSeq(11, time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected waiting for a write that never happens to time out, got %v", err)
+	}
+	if err := q.WaitForReadSeq(11, time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected waiting for a read that never happens to time out, got %v", err)
+	}
+	go q.Dispose()
+	if err := q.WaitForReadSeq(11, 0); err == nil {
+		t.Fatal("expected waiting on a disposed queue to fail")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	q := NewRingBuffer[interface{}](8, WithHistory(3))
+	if h := q.History(); len(h) != 0 {
+		t.Fatalf("expected no samples yet, got %v", h)
+	}
+	q.Sample()
+	q.Put(1)
+	q.Sample()
+	if h := q.History(); len(h) != 2 || h[0].Len != 0 || h[1].Len != 1 {
+		t.Fatalf("expected lengths 0 and 1, got %v", h)
+	}
+
+	// The oldest samples are dropped once the history is full.
+	for i := 2; i <= 5; i++ {
+		q.Put(i)
+		q.Sample()
+	}
+	h := q.History()
+	if len(h) != 3 {
+		t.Fatalf("expected 3 samples, got %v", h)
+	}
+	for i, s := range h {
+		if s.Len != uint64(i+3) {
+			t.Fatalf("expected lengths 3 to 5, got %v", h)
+		}
+		if i > 0 && s.Time.Before(h[i-1].Time) {
+			t.Fatalf("expected samples in chronological order, got %v", h)
+		}
+	}
+
+	q = NewRingBuffer[interface{}](8)
+	q.Sample()
+	if h := q.History(); h != nil {
+		t.Fatalf("expected no history without WithHistory, got %v", h)
+	}
+}
+
+func TestTypedItems(t *testing.T) {
+	type point struct{ x, y int }
+	q := NewRingBuffer[point](4)
+	for i := 0; i < 3; i++ {
+		if err := q.Put(point{i, -i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		p, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != (point{i, -i}) {
+			t.Fatalf("expected %v, got %v", point{i, -i}, p)
+		}
+	}
+
+	// Error paths return the zero value of the item type.
+	if p, err := q.Poll(time.Millisecond); err == nil || p != (point{}) {
+		t.Fatalf("expected the zero value and a timeout, got %v, %v", p, err)
+	}
+	q.Put(point{1, 2})
+	q.Dispose()
+	if p, err := q.Get(); err == nil || p != (point{}) {
+		t.Fatalf("expected the zero value and an error, got %v, %v", p, err)
+	}
+	if p, ok, err := q.TryGet(); err == nil || ok || p != (point{}) {
+		t.Fatalf("expected the zero value and an error, got %v, %v, %v", p, ok, err)
+	}
+}
+
+// BenchmarkMPMCTypedItems shows what putting small structs costs with and
+// without boxing them in an interface{}.
+func BenchmarkMPMCTypedItems(b *testing.B) {
+	type point struct{ x, y int }
+	b.Run("interface", func(b *testing.B) {
+		q := NewRingBuffer[interface{}](8192)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.Put(point{i, i})
+			q.Get()
+		}
+	})
+	b.Run("typed", func(b *testing.B) {
+		q := NewRingBuffer[point](8192)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.Put(point{i, i})
+			q.Get()
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package mpmc
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// overflow is an unbounded FIFO that absorbs bursts once the ring is full.
+// Unlike the ring it is guarded by a mutex, so producers and consumers
+// touching it are no longer lock-free.
+type overflow[T any] struct {
+	len     uint64 // Shared. Number of items in items.
+	spilled uint64 // Shared. Number of items ever added to items.
+	mu      sync.Mutex
+	items   []T
+}
+
+// WithOverflow makes Put, Offer and PutWith never block or fail on a full
+// queue: items that do not fit in the ring are spilled to an unbounded,
+// mutex-guarded overflow. Get drains the ring before the overflow, and
+// while the overflow is non-empty new items go to the overflow too, so
+// each producer's items are still received in order. The ring path stays
+// lock-free as long as the overflow is empty.
+func WithOverflow() Option {
+	return func(o *options) {
+		o.overflowing = true
+	}
+}
+
+// Overflowed returns the number of items currently held in the overflow.
+func (rb *RingBuffer[T]) Overflowed() uint64 {
+	if rb.overflow == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&rb.overflow.len)
+}
+
+func (rb *RingBuffer[T]) spill(item T) error {
+	o := rb.overflow
+	if atomic.LoadUint64(&o.len) == 0 {
+		ok, err := rb.enqueue(item, true, nil)
+		if ok || err != nil {
+			return err
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if atomic.LoadUint64(&rb.disposed) == 1 {
+		return rb.disposedErr()
+	}
+	o.items = append(o.items, item)
+	atomic.AddUint64(&o.len, 1)
+	atomic.AddUint64(&o.spilled, 1)
+	return nil
+}
+
+func (o *overflow[T]) pop() (T, bool) {
+	var zero T
+	if atomic.LoadUint64(&o.len) == 0 {
+		return zero, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) == 0 {
+		return zero, false
+	}
+	data := o.items[0]
+	o.items[0] = zero
+	o.items = o.items[1:]
+	if len(o.items) == 0 {
+		o.items = nil
+	}
+	atomic.AddUint64(&o.len, ^uint64(0))
+	return data, true
+}
@@ -0,0 +1,27 @@
+package mpmc
+
+import "sync/atomic"
+
+// RegisterProducer records that one more producer is putting to the queue.
+// It is bookkeeping only: puts work the same whether or not their producer
+// registered, and the queue never looks at the count itself.
+func (rb *RingBuffer[T]) RegisterProducer() {
+	atomic.AddInt64(&rb.producers, 1)
+}
+
+// UnregisterProducer records that a registered producer is done and returns
+// how many are left, so the last one out can tell, e.g. to dispose the queue
+// once consumers have drained it.  It panics if no producer is registered.
+func (rb *RingBuffer[T]) UnregisterProducer() int64 {
+	n := atomic.AddInt64(&rb.producers, -1)
+	if n < 0 {
+		atomic.AddInt64(&rb.producers, 1)
+		panic(`queue: unregistered producer`)
+	}
+	return n
+}
+
+// ActiveProducers returns how many producers are registered.
+func (rb *RingBuffer[T]) ActiveProducers() int64 {
+	return atomic.LoadInt64(&rb.producers)
+}
@@ -0,0 +1,65 @@
+package mpmc
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// WithReplay makes consumers leave the items they get in their slots until
+// later puts reuse them, so that SeekRead can seek backward and replay them.
+// Those items stay reachable, and can't be garbage collected, until then.
+func WithReplay() Option {
+	return func(o *options) {
+		o.replay = true
+	}
+}
+
+// ErrSeekOutOfRange is returned by SeekRead for a sequence that is not
+// buffered.
+var ErrSeekOutOfRange = errors.New(`queue: seek out of range`)
+
+// SeekRead moves the read cursor to seq, so the next get returns the item
+// put with that sequence, counting puts from 0.  Seeking forward skips the
+// items in between, which must all be published.  Seeking backward replays
+// items already consumed, as long as their slots have not been reused by
+// later puts, i.e. as long as seq is at most Cap behind the write cursor,
+// and requires the queue to be created with WithReplay.  Any other seq
+// returns ErrSeekOutOfRange and leaves the queue unchanged.  Items spilled
+// to the overflow have no sequence and are not replayed.
+//
+// SeekRead is meant for a single replay consumer: it must not be called
+// concurrently with puts or other gets, e.g. while producers are paused.
+func (rb *RingBuffer[T]) SeekRead(seq uint64) error {
+	rd := atomic.LoadUint64(&rb.read)
+	wr := atomic.LoadUint64(&rb.write)
+	if int64(wr-seq) < 0 || wr-seq > rb.Cap() {
+		return ErrSeekOutOfRange
+	}
+
+	if int64(seq-rd) >= 0 {
+		for pos := rd; pos != seq; pos++ {
+			if atomic.LoadUint64(&rb.node(pos).position) != pos+1 {
+				return ErrSeekOutOfRange
+			}
+		}
+		for pos := rd; pos != seq; pos++ {
+			n := rb.node(pos)
+			rb.clear(n)
+			atomic.StoreUint64(&n.position, pos+rb.Cap())
+		}
+	} else {
+		if !rb.replay {
+			return ErrSeekOutOfRange
+		}
+		for pos := seq; pos != rd; pos++ {
+			if atomic.LoadUint64(&rb.node(pos).position) != pos+rb.Cap() {
+				return ErrSeekOutOfRange
+			}
+		}
+		for pos := seq; pos != rd; pos++ {
+			atomic.StoreUint64(&rb.node(pos).position, pos+1)
+		}
+	}
+	atomic.StoreUint64(&rb.read, seq)
+	return nil
+}
@@ -0,0 +1,246 @@
+package mpmc
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The simulation below runs scripted producers and consumers on their own
+// goroutines, but lets only one of them run at a time: every actor stops at
+// each step of the put and get protocols, reported through RingBuffer.yield,
+// and a scheduler picks the actor that takes the next step.  Exploring every
+// choice of actor at every step checks the queue against a reference FIFO
+// under every interleaving of the scripts.
+
+// simMaxSteps bounds a single interleaving, to catch livelocks.
+const simMaxSteps = 500
+
+// simPreemptions bounds how many times an interleaving switches away from
+// an actor that could have taken another step.  Most concurrency bugs show
+// up within a couple of preemptions, and the bound keeps the number of
+// interleavings polynomial in the length of the scripts.
+const simPreemptions = 2
+
+type simActor struct {
+	script  func(s *sim, a *simActor)
+	resume  chan struct{}
+	steps   chan string // Steps taken, "" once the script is done.
+	done    bool
+	waiting bool        // Last step found the queue full or empty.
+	claimed bool        // Last step claimed a slot, the next publishes it.
+	ran     int         // Last step taken.
+	item    interface{} // Being put.
+	expect  interface{} // Due to be got, according to the reference.
+}
+
+type sim struct {
+	rb     *RingBuffer[interface{}]
+	actors []*simActor
+	cur    *simActor
+	ref    []interface{} // The reference FIFO.
+	errs   []string
+}
+
+func (s *sim) errorf(format string, args ...interface{}) {
+	s.errs = append(s.errs, fmt.Sprintf(format, args...))
+}
+
+// yield reports a step of the current actor to the scheduler and waits for
+// its next turn.  Claiming a slot is the linearization point of puts and
+// gets, which is where the reference FIFO is updated.
+func (s *sim) yield(step string) {
+	a := s.cur
+	switch step {
+	case "put-claimed":
+		s.ref = append(s.ref, a.item)
+	case "get-claimed":
+		if len(s.ref) == 0 {
+			s.errorf("get claimed a slot of an empty queue")
+			break
+		}
+		a.expect, s.ref = s.ref[0], s.ref[1:]
+	}
+	a.waiting = step == "put-retry" || step == "get-retry"
+	a.steps <- step
+	<-a.resume
+}
+
+func simProducer(items ...interface{}) func(*sim, *simActor) {
+	return func(s *sim, a *simActor) {
+		for _, item := range items {
+			a.item = item
+			if err := s.rb.Put(item); err != nil {
+				s.errorf("put %v: %v", item, err)
+			}
+		}
+	}
+}
+
+func simConsumer(gets int) func(*sim, *simActor) {
+	return func(s *sim, a *simActor) {
+		for i := 0; i < gets; i++ {
+			got, err := s.rb.Get()
+			if err != nil {
+				s.errorf("get: %v", err)
+			} else if got != a.expect {
+				s.errorf("got %v, the reference has %v", got, a.expect)
+			}
+		}
+	}
+}
+
+// run plays one interleaving, picking schedule[i] among the runnable actors
+// at step i, and the first one past the end of schedule.  It returns the
+// choices made and how many actors were runnable at each step.
+func (s *sim) run(size uint64, scripts []func(*sim, *simActor), schedule []int) ([]int, []int) {
+	s.rb = NewRingBuffer[interface{}](size)
+	s.rb.yield = s.yield
+	s.actors, s.ref, s.errs = nil, nil, nil
+	for _, script := range scripts {
+		a := &simActor{
+			script: script,
+			resume: make(chan struct{}),
+			steps:  make(chan string),
+		}
+		s.actors = append(s.actors, a)
+		go func() {
+			<-a.resume
+			a.script(s, a)
+			a.steps <- ""
+		}()
+	}
+
+	var (
+		choices, alts []int
+		prev          *simActor
+		preemptions   int
+	)
+	for step := 0; ; step++ {
+		if step == simMaxSteps {
+			s.errorf("no progress after %d steps", step)
+			break
+		}
+		// The previous actor goes first, picking any other preempts it.
+		var runnable []*simActor
+		if prev != nil && !prev.done && !prev.waiting {
+			runnable = append(runnable, prev)
+		}
+		if len(runnable) == 0 || preemptions < simPreemptions {
+			for _, a := range s.actors {
+				if a != prev && !a.done && !a.waiting {
+					runnable = append(runnable, a)
+				}
+			}
+		}
+		if len(runnable) == 0 {
+			// Everyone is waiting: let the one that waited longest check
+			// again.  Choosing here would explore unfair schedules that
+			// starve an actor everyone else is waiting on.
+			for _, a := range s.actors {
+				if !a.done && (len(runnable) == 0 || a.ran < runnable[0].ran) {
+					runnable = []*simActor{a}
+				}
+			}
+		}
+		if len(runnable) == 0 {
+			break
+		}
+		var choice int
+		if step < len(schedule) {
+			choice = schedule[step]
+		}
+		choices = append(choices, choice)
+		alts = append(alts, len(runnable))
+
+		a := runnable[choice]
+		if a != prev && runnable[0] == prev {
+			preemptions++
+		}
+		prev = a
+		s.cur = a
+		a.resume <- struct{}{}
+		taken := <-a.steps
+		a.ran = step
+		a.done = taken == ""
+		// Only claiming and publishing slots change the queue, so actors
+		// waiting on it are woken up by those steps alone.
+		changed := a.claimed || taken == "put-claimed" || taken == "get-claimed"
+		a.claimed = taken == "put-claimed" || taken == "get-claimed"
+		if changed {
+			for _, other := range s.actors {
+				if other != a {
+					other.waiting = false
+				}
+			}
+		}
+	}
+
+	// Release actors stuck after a livelock.
+	s.rb.yield = nil
+	s.rb.Dispose()
+	for _, a := range s.actors {
+		if !a.done {
+			go func(a *simActor) {
+				for {
+					select {
+					case a.resume <- struct{}{}:
+					case <-a.steps:
+					}
+				}
+			}(a)
+		}
+	}
+	return choices, alts
+}
+
+// explore runs every interleaving of scripts, depth first, and returns the
+// number of interleavings.
+func explore(t *testing.T, size uint64, scripts ...func(*sim, *simActor)) int {
+	s := &sim{}
+	var schedule []int
+	for runs := 1; ; runs++ {
+		choices, alts := s.run(size, scripts, schedule)
+		if len(s.ref) != 0 {
+			s.errorf("%d items left in the reference", len(s.ref))
+		}
+		if len(s.errs) != 0 {
+			t.Fatalf("interleaving %v: %v", choices, s.errs)
+		}
+
+		// Backtrack to the last step with an untried choice.
+		i := len(choices) - 1
+		for i >= 0 && choices[i]+1 == alts[i] {
+			i--
+		}
+		if i < 0 {
+			return runs
+		}
+		schedule = append(choices[:i:i], choices[i]+1)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	for _, sc := range []struct {
+		name    string
+		size    uint64
+		scripts []func(*sim, *simActor)
+	}{
+		{"2 producers 1 consumer", 2, []func(*sim, *simActor){
+			simProducer("a"), simProducer("b"), simConsumer(2),
+		}},
+		{"1 producer 2 consumers", 2, []func(*sim, *simActor){
+			simProducer("a", "b"), simConsumer(1), simConsumer(1),
+		}},
+		{"full queue", 2, []func(*sim, *simActor){
+			simProducer("a", "b", "c"), simConsumer(3),
+		}},
+		{"2 producers 2 consumers", 2, []func(*sim, *simActor){
+			simProducer("a"), simProducer("b"), simConsumer(1), simConsumer(1),
+		}},
+	} {
+		t.Run(sc.name, func(t *testing.T) {
+			runs := explore(t, sc.size, sc.scripts...)
+			t.Logf("%d interleavings", runs)
+		})
+	}
+}
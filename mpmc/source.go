@@ -0,0 +1,46 @@
+package mpmc
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrExhausted is returned by a Source's Get once every item was taken.
+var ErrExhausted = errors.New(`queue: exhausted`)
+
+// Source is a one-shot, read-only queue over an existing slice of T, handing
+// out each of its items to exactly one of any number of consumers.  There is
+// no producer: the items are all there from the start, so a Get is a single
+// atomic add claiming the next index.
+type Source[T any] struct {
+	_     [8]uint64
+	next  uint64 // Shared only with consumers.
+	_     [8]uint64
+	items []T
+}
+
+// NewSourceFromSlice returns a Source over items, which it uses as is
+// without copying them.  The caller must not modify items until the Source
+// is exhausted.
+func NewSourceFromSlice[T any](items []T) *Source[T] {
+	return &Source[T]{items: items}
+}
+
+// Get returns the next item, or ErrExhausted if there are none left.
+func (s *Source[T]) Get() (T, error) {
+	i := atomic.AddUint64(&s.next, 1) - 1
+	if i >= uint64(len(s.items)) {
+		var zero T
+		return zero, ErrExhausted
+	}
+	return s.items[i], nil
+}
+
+// Remaining returns the number of items not taken yet.
+func (s *Source[T]) Remaining() uint64 {
+	next := atomic.LoadUint64(&s.next)
+	if next >= uint64(len(s.items)) {
+		return 0
+	}
+	return uint64(len(s.items)) - next
+}
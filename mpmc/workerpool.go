@@ -0,0 +1,104 @@
+package mpmc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrShutDown is returned by Submit on a pool that was shut down.
+var ErrShutDown = errors.New(`workerpool: shut down`)
+
+// HandlerErrors is the list of errors returned by a WorkerPool's handler.
+type HandlerErrors []error
+
+func (errs HandlerErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("workerpool: %d handler errors: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// WorkerPool runs a fixed number of goroutines handling the items submitted
+// to it, each item by exactly one of them.
+type WorkerPool[T any] struct {
+	rb      *RingBuffer[T]
+	handler func(T) error
+
+	mu     sync.RWMutex // Guards closed against concurrent Submit.
+	closed bool
+
+	pending sync.WaitGroup // Items submitted but not handled yet.
+	workers sync.WaitGroup
+
+	errMu sync.Mutex
+	errs  HandlerErrors
+}
+
+// NewWorkerPool starts workers goroutines calling handler with the items
+// submitted to the pool, which are queued in a ring buffer of the specified
+// size.
+func NewWorkerPool[T any](size uint64, workers int, handler func(T) error) *WorkerPool[T] {
+	wp := &WorkerPool[T]{
+		rb:      NewRingBuffer[T](size),
+		handler: handler,
+	}
+	for i := 0; i < workers; i++ {
+		wp.workers.Add(1)
+		go wp.work()
+	}
+	return wp
+}
+
+func (wp *WorkerPool[T]) work() {
+	defer wp.workers.Done()
+	for {
+		item, err := wp.rb.Get()
+		if err != nil {
+			return
+		}
+		if err := wp.handler(item); err != nil {
+			wp.errMu.Lock()
+			wp.errs = append(wp.errs, err)
+			wp.errMu.Unlock()
+		}
+		wp.pending.Done()
+	}
+}
+
+// Submit queues item to be handled by one of the workers.  If the queue is
+// full, this call will block until a worker takes an item.  An error will be
+// returned if the pool is shut down.
+func (wp *WorkerPool[T]) Submit(item T) error {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	if wp.closed {
+		return ErrShutDown
+	}
+	wp.pending.Add(1)
+	if err := wp.rb.Put(item); err != nil {
+		wp.pending.Done()
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting items, waits for every submitted item to be
+// handled, then stops the workers.  It returns the errors returned by the
+// handler as HandlerErrors, or nil if there were none.
+func (wp *WorkerPool[T]) Shutdown() error {
+	wp.mu.Lock()
+	wp.closed = true
+	wp.mu.Unlock()
+
+	wp.pending.Wait()
+	wp.rb.Dispose()
+	wp.workers.Wait()
+
+	if len(wp.errs) == 0 {
+		return nil
+	}
+	return wp.errs
+}
@@ -0,0 +1,11 @@
+package mpsc
+
+// fastForward moves the cursors of an empty queue to seq, as if seq items
+// had gone through it, to test sequences wrapping around.
+func (rb *RingBuffer[T]) fastForward(seq uint64) {
+	rb.write, rb.read = seq, seq
+	for i := uint64(0); i < rb.Cap(); i++ {
+		s := seq + i
+		rb.nodes[s&rb.mask].position = s
+	}
+}
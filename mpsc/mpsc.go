@@ -0,0 +1,276 @@
+package mpsc
+
+import (
+	"lockfree/internal/pause"
+	"lockfree/queue"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// minSize is 2 because size of 1 is invalid: node's position
+// uses index+1 as a flag to let the consumer know data is ready to be
+// read, this breaks when size is set to 1.
+const minSize = 2
+
+// roundUp takes a uint64 greater than 0 and rounds it up to the next
+// power of 2.
+func roundUp(v uint64) uint64 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+type node[T any] struct {
+	position uint64 // Shared.
+	data     T
+}
+
+type nodes[T any] []node[T]
+
+// RingBuffer is a MPSC lockfree queue of T. Producers claim slots like
+// Dmitry's bounded mpmc queue, but with a single consumer the read side needs
+// no CAS.  It has the API of mpmc.RingBuffer[T] for the methods they share.
+type RingBuffer[T any] struct {
+	_        [8]uint64
+	write    uint64 // Shared only with producers.
+	_        [8]uint64
+	read     uint64 // Owned by consumer.
+	_        [8]uint64
+	mask     uint64
+	disposed uint64
+	_        [8]uint64
+	nodes    nodes[T]
+
+	options
+}
+
+// options holds the settings of a RingBuffer made by its Options.
+type options struct {
+	// pureSpin and cpuPause set how the wait loops spin, see spin.
+	pureSpin bool
+	cpuPause bool
+
+	// disposeErr replaces the default error of calls on a disposed queue.
+	disposeErr error
+}
+
+// Option configures a RingBuffer at construction time.
+type Option func(o *options)
+
+// WithPureSpin makes the wait loops of Get, Put and friends busy-wait
+// without calling runtime.Gosched, like mpmc.WithPureSpin.
+func WithPureSpin() Option {
+	return func(o *options) {
+		o.pureSpin = true
+	}
+}
+
+// WithCPUPause is WithPureSpin executing the CPU's spin-wait hint on every
+// iteration of the wait loops, like mpmc.WithCPUPause.
+func WithCPUPause() Option {
+	return func(o *options) {
+		o.pureSpin = true
+		o.cpuPause = true
+	}
+}
+
+// WithDisposeError makes every method that fails because the queue is
+// disposed return err instead of the default error.
+func WithDisposeError(err error) Option {
+	return func(o *options) {
+		o.disposeErr = err
+	}
+}
+
+// spin is called on every iteration of a wait loop.
+func (rb *RingBuffer[T]) spin() {
+	switch {
+	case rb.cpuPause:
+		pause.Pause()
+	case !rb.pureSpin:
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+}
+
+// disposedErr returns the error for calls on a disposed queue.
+func (rb *RingBuffer[T]) disposedErr() error {
+	if rb.disposeErr != nil {
+		return rb.disposeErr
+	}
+	return ErrDisposed
+}
+
+func (rb *RingBuffer[T]) init(size uint64) {
+	size = roundUp(size)
+	rb.nodes = make(nodes[T], size)
+	for i := uint64(0); i < size; i++ {
+		rb.nodes[i] = node[T]{position: i}
+	}
+	rb.mask = size - 1 // so we don't have to do this with every put/get operation
+}
+
+// NewRingBuffer will allocate, initialize, and return a ring buffer
+// with the specified size.
+func NewRingBuffer[T any](size uint64, opts ...Option) *RingBuffer[T] {
+	rb := &RingBuffer[T]{}
+	if size < minSize {
+		size = minSize
+	}
+	for _, opt := range opts {
+		opt(&rb.options)
+	}
+	rb.init(size)
+	return rb
+}
+
+// Dispose will dispose of this queue and free any blocked threads
+// in the Put and/or Get methods.  Calling those methods on a disposed
+// queue will return an error.
+func (rb *RingBuffer[T]) Dispose() {
+	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this queue has been
+// disposed.
+func (rb *RingBuffer[T]) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *RingBuffer[T]) Cap() uint64 {
+	return uint64(len(rb.nodes))
+}
+
+// Get will return the next item in the queue.  This call will block
+// if the queue is empty.  This call will unblock when an item is added
+// to the queue or Dispose is called on the queue.  An error will be returned
+// if the queue is disposed.
+func (rb *RingBuffer[T]) Get() (T, error) {
+	return rb.Poll(0)
+}
+
+// Poll will return the next item in the queue.  This call will block
+// if the queue is empty.  This call will unblock when an item is added
+// to the queue, Dispose is called on the queue, or the timeout is reached. An
+// error will be returned if the queue is disposed or a timeout occurs. A
+// non-positive timeout will block indefinitely.
+func (rb *RingBuffer[T]) Poll(timeout time.Duration) (T, error) {
+	var (
+		zero  T
+		pos   = rb.read
+		n     = &rb.nodes[pos&rb.mask]
+		start time.Time
+	)
+	if timeout > 0 {
+		start = time.Now()
+	}
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return zero, rb.disposedErr()
+		}
+
+		// Published.
+		if atomic.LoadUint64(&n.position) == pos+1 {
+			break
+		}
+
+		if timeout > 0 && time.Since(start) >= timeout {
+			return zero, ErrTimeout
+		}
+
+		rb.spin()
+	}
+	data := n.data
+	n.data = zero
+	rb.read = pos + 1
+	atomic.StoreUint64(&n.position, pos+rb.mask+1) // cache coherence traffic
+	return data, nil
+}
+
+// DrainAvailable removes and returns the items published so far, up to max,
+// in one shot without waiting.  Items are only taken up to the first slot a
+// producer has claimed but not yet filled, so order is preserved.  It returns
+// nil if no item is available.  Items left in a disposed queue can still be
+// drained.  Only the single consumer may call DrainAvailable.
+func (rb *RingBuffer[T]) DrainAvailable(max int) []T {
+	var (
+		items []T
+		zero  T
+	)
+	pos := rb.read
+	for len(items) < max {
+		n := &rb.nodes[pos&rb.mask]
+		if atomic.LoadUint64(&n.position) != pos+1 {
+			break
+		}
+		items = append(items, n.data)
+		n.data = zero
+		atomic.StoreUint64(&n.position, pos+rb.mask+1) // cache coherence traffic
+		pos++
+	}
+	rb.read = pos
+	return items
+}
+
+// Put adds the provided item to the queue.  If the queue is full, this
+// call will block until an item is added to the queue or Dispose is called
+// on the queue.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer[T]) Put(item T) error {
+	_, err := rb.put(item, false)
+	return err
+}
+
+// Offer adds the provided item to the queue if there is space.  If the queue
+// is full, this call will return false.  An error will be returned if the
+// queue is disposed.
+//
+// WARNING: not guaranteed to be full when multiple producers try to put concurrently!
+func (rb *RingBuffer[T]) Offer(item T) (bool, error) {
+	return rb.put(item, true)
+}
+
+func (rb *RingBuffer[T]) put(item T, offer bool) (bool, error) {
+	var n *node[T]
+	pos := atomic.LoadUint64(&rb.write)
+L:
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return false, rb.disposedErr()
+		}
+
+		n = &rb.nodes[pos&rb.mask]
+		seq := atomic.LoadUint64(&n.position)
+		switch dif := int64(seq - pos); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&rb.write, pos, pos+1) {
+				break L
+			}
+		case dif < 0:
+			// Full: the consumer hasn't freed this slot yet.
+			if offer {
+				return false, nil
+			}
+		default:
+			pos = atomic.LoadUint64(&rb.write)
+		}
+
+		rb.spin()
+	}
+
+	n.data = item
+	atomic.StoreUint64(&n.position, pos+1) // cache coherence traffic
+	return true, nil
+}
@@ -0,0 +1,159 @@
+package mpsc
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestGetAndOffer(t *testing.T) {
+	q := NewRingBuffer[int](2)
+	for i := 0; i < 2; i++ {
+		if ok, err := q.Offer(i); !ok || err != nil {
+			t.Fatalf("expected offer to succeed, got %v, %v", ok, err)
+		}
+	}
+	if ok, _ := q.Offer(2); ok {
+		t.Fatal("expected offer on a full queue to fail")
+	}
+	for i := 0; i < 2; i++ {
+		if got, _ := q.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if _, err := q.Poll(time.Millisecond); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+	q.Dispose()
+	if err := q.Put(0); err == nil {
+		t.Fatal("expected put on a disposed queue to fail")
+	}
+}
+
+func TestDrainAvailable(t *testing.T) {
+	const (
+		producers = 4
+		perProd   = 10000
+	)
+	q := NewRingBuffer[int](64)
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProd; i++ {
+				q.Put(p*perProd + i)
+			}
+		}(p)
+	}
+
+	seen := make([]bool, producers*perProd)
+	last := make([]int, producers)
+	for p := range last {
+		last[p] = -1
+	}
+	for got := 0; got < producers*perProd; {
+		items := q.DrainAvailable(16)
+		if len(items) > 16 {
+			t.Fatalf("expected at most 16 items, got %d", len(items))
+		}
+		for _, item := range items {
+			v := item
+			if seen[v] {
+				t.Fatalf("item %d delivered twice", v)
+			}
+			seen[v] = true
+			// Each producer's items come out in order.
+			p := v / perProd
+			if v <= last[p] {
+				t.Fatalf("item %d of producer %d after %d", v, p, last[p])
+			}
+			last[p] = v
+		}
+		got += len(items)
+		if len(items) == 0 {
+			runtime.Gosched()
+		}
+	}
+	wg.Wait()
+	if items := q.DrainAvailable(16); items != nil {
+		t.Fatalf("expected an empty queue, got %v", items)
+	}
+}
+
+func BenchmarkMPSCConcurrentWrite(b *testing.B) {
+	q := NewRingBuffer[string](8192)
+
+	b.ResetTimer()
+	// 1 Consumer.
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	// N Producers.
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.Put(`a`)
+		}
+	})
+}
+
+// TestNodeSize documents the size of a node on 64-bit platforms: a uint64 and
+// an interface{}, so nodes don't line up with 64 byte cache lines and some
+// straddle two.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node[interface{}]{}); size != 24 {
+		t.Fatalf("expected a 24 byte node, got %d", size)
+	}
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer[int](64)
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.read >= uint64(ops) {
+		t.Fatalf("expected the read sequence to have wrapped around, got %d", q.read)
+	}
+}
+
+func TestDisposeError(t *testing.T) {
+	errClosed := errors.New("closed")
+	q := NewRingBuffer[int](2, WithDisposeError(errClosed), WithPureSpin())
+	q.Dispose()
+	if _, err := q.Get(); err != errClosed {
+		t.Fatalf("expected %v, got %v", errClosed, err)
+	}
+	if err := q.Put(0); err != errClosed {
+		t.Fatalf("expected %v, got %v", errClosed, err)
+	}
+}
@@ -0,0 +1,67 @@
+package pair_spsc
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ContextQueue is a SPSC queue of items that each carry the context of the
+// request they belong to.  Items whose context is done by the time they are
+// dequeued were abandoned by their caller, so Get skips them.
+type ContextQueue struct {
+	rb        *RingBuffer
+	cancelled uint64 // Shared.
+}
+
+// NewContextQueue will allocate, initialize, and return a ContextQueue with
+// the specified size.
+func NewContextQueue(size uint64) *ContextQueue {
+	return &ContextQueue{rb: NewRingBuffer(size)}
+}
+
+// Put adds the provided item to the queue along with ctx.  If the queue is
+// full, this call will block until an item is removed from the queue or
+// Dispose is called on the queue.  An error will be returned if the queue
+// is disposed.  The item is put even if ctx is already done.  A nil ctx is
+// taken as context.Background().
+func (q *ContextQueue) Put(ctx context.Context, item interface{}) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return q.rb.PutPair(ctx, item)
+}
+
+// Get will return the next item in the queue whose context is not done,
+// along with that context.  This call will block if the queue is empty.  An
+// error will be returned if the queue is disposed.
+func (q *ContextQueue) Get() (context.Context, interface{}, error) {
+	for {
+		a, item, err := q.rb.GetPair()
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx := a.(context.Context)
+		if ctx.Err() == nil {
+			return ctx, item, nil
+		}
+		atomic.AddUint64(&q.cancelled, 1)
+	}
+}
+
+// Cancelled returns the number of items Get skipped as their context was
+// done.
+func (q *ContextQueue) Cancelled() uint64 {
+	return atomic.LoadUint64(&q.cancelled)
+}
+
+// Dispose will dispose of this queue and free any blocked threads in the Put
+// and/or Get methods.  Calling those methods on a disposed queue will return
+// an error.
+func (q *ContextQueue) Dispose() {
+	q.rb.Dispose()
+}
+
+// Cap returns the capacity of this queue.
+func (q *ContextQueue) Cap() uint64 {
+	return q.rb.Cap()
+}
@@ -0,0 +1,97 @@
+package pair_spsc
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// MemoryBoundedQueue is a SPSC queue bounded both by a number of items and
+// by the total size of the items in it, whichever binds first, for items
+// whose sizes vary too much for a count to bound memory.  Each item is
+// queued along with its size, which the producer provides, in a slice
+// parallel to the nodes so that it isn't boxed.
+type MemoryBoundedQueue struct {
+	rb     *RingBuffer
+	budget int64
+	bytes  int64 // Shared. Size of the items in the queue.
+}
+
+// NewMemoryBoundedQueue will allocate, initialize, and return a
+// MemoryBoundedQueue holding up to size items, and up to budget bytes of
+// them.
+func NewMemoryBoundedQueue(size uint64, budget int64) *MemoryBoundedQueue {
+	rb := NewRingBuffer(size)
+	rb.sizes = make([]int64, rb.Cap())
+	return &MemoryBoundedQueue{rb: rb, budget: budget}
+}
+
+// Put adds the provided item of the given size to the queue.  If the queue
+// is full, or adding the item would exceed the byte budget, this call will
+// block until enough items are removed from the queue or Dispose is called
+// on the queue.  An item larger than the whole budget is only added to an
+// empty queue.  An error will be returned if the queue is disposed.
+func (q *MemoryBoundedQueue) Put(item interface{}, size int) error {
+	_, err := q.put(item, size, false)
+	return err
+}
+
+// Offer adds the provided item of the given size to the queue if there is
+// space and budget for it.  Otherwise, this call will return false.  An
+// error will be returned if the queue is disposed.
+func (q *MemoryBoundedQueue) Offer(item interface{}, size int) (bool, error) {
+	return q.put(item, size, true)
+}
+
+func (q *MemoryBoundedQueue) put(item interface{}, size int, offer bool) (bool, error) {
+	for {
+		if q.rb.IsDisposed() {
+			return false, ErrDisposed
+		}
+		// Only the producer adds to bytes, so it can't grow past the check.
+		bytes := atomic.LoadInt64(&q.bytes)
+		if bytes == 0 || bytes+int64(size) <= q.budget {
+			break
+		}
+		if offer {
+			return false, nil
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	// Counted before it is published, so the consumer never subtracts the
+	// size of an item not counted yet.
+	atomic.AddInt64(&q.bytes, int64(size))
+	ok, err := q.rb.put(item, nil, int64(size), offer)
+	if !ok {
+		atomic.AddInt64(&q.bytes, -int64(size))
+	}
+	return ok, err
+}
+
+// Get will return the next item in the queue, releasing its size from the
+// budget.  This call will block if the queue is empty.  An error will be
+// returned if the queue is disposed.
+func (q *MemoryBoundedQueue) Get() (interface{}, error) {
+	item, _, size, err := q.rb.poll(0)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&q.bytes, -size)
+	return item, nil
+}
+
+// Bytes returns the total size of the items in the queue.
+func (q *MemoryBoundedQueue) Bytes() int64 {
+	return atomic.LoadInt64(&q.bytes)
+}
+
+// Dispose will dispose of this queue and free any blocked threads in the Put
+// and/or Get methods.  Calling those methods on a disposed queue will return
+// an error.
+func (q *MemoryBoundedQueue) Dispose() {
+	q.rb.Dispose()
+}
+
+// Cap returns the capacity of this queue, in items.
+func (q *MemoryBoundedQueue) Cap() uint64 {
+	return q.rb.Cap()
+}
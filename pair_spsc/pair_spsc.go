@@ -0,0 +1,178 @@
+package pair_spsc
+
+import (
+	"lockfree/queue"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// roundUp takes a uint64 greater than 0 and rounds it up to the next
+// power of 2.
+func roundUp(v uint64) uint64 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+type node struct {
+	a interface{}
+	b interface{}
+}
+
+type nodes []node
+
+// RingBuffer is a SPSC lockfree queue of pairs. Each node holds both values
+// inline, so a pair costs one publish and no wrapper allocation.
+type RingBuffer struct {
+	_        [8]uint64
+	write    uint64 // Shared, owned by producer.
+	_        [8]uint64
+	read     uint64 // Shared, owned by consumer.
+	_        [8]uint64
+	mask     uint64
+	disposed uint64
+	_        [8]uint64
+	nodes    nodes
+
+	// sizes holds a size per node, next to the nodes rather than boxed in
+	// one of the pair's values.  Nil unless the queue backs a
+	// MemoryBoundedQueue.
+	sizes []int64
+}
+
+func (rb *RingBuffer) init(size uint64) {
+	size = roundUp(size)
+	rb.nodes = make(nodes, size)
+	rb.mask = size - 1 // so we don't have to do this with every put/get operation
+}
+
+// NewRingBuffer will allocate, initialize, and return a ring buffer
+// with the specified size.
+func NewRingBuffer(size uint64) *RingBuffer {
+	rb := &RingBuffer{}
+	rb.init(size)
+	return rb
+}
+
+// Dispose will dispose of this queue and free any blocked threads
+// in the Put and/or Get methods.  Calling those methods on a disposed
+// queue will return an error.
+func (rb *RingBuffer) Dispose() {
+	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this queue has been
+// disposed.
+func (rb *RingBuffer) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *RingBuffer) Cap() uint64 {
+	return uint64(len(rb.nodes))
+}
+
+// GetPair will return the next pair in the queue.  This call will block
+// if the queue is empty.  This call will unblock when a pair is added
+// to the queue or Dispose is called on the queue.  An error will be returned
+// if the queue is disposed.
+func (rb *RingBuffer) GetPair() (interface{}, interface{}, error) {
+	return rb.PollPair(0)
+}
+
+// PollPair will return the next pair in the queue.  This call will block
+// if the queue is empty.  This call will unblock when a pair is added
+// to the queue, Dispose is called on the queue, or the timeout is reached. An
+// error will be returned if the queue is disposed or a timeout occurs. A
+// non-positive timeout will block indefinitely.
+func (rb *RingBuffer) PollPair(timeout time.Duration) (interface{}, interface{}, error) {
+	a, b, _, err := rb.poll(timeout)
+	return a, b, err
+}
+
+// poll is PollPair also returning the size of the pair, see sizes.
+func (rb *RingBuffer) poll(timeout time.Duration) (interface{}, interface{}, int64, error) {
+	var start time.Time
+	if timeout > 0 {
+		start = time.Now()
+	}
+
+	rd := atomic.LoadUint64(&rb.read)
+	for {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return nil, nil, 0, ErrDisposed
+		}
+		wr := atomic.LoadUint64(&rb.write)
+		// Not emtpy.
+		if rd != wr {
+			break
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, nil, 0, ErrTimeout
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	n := &rb.nodes[rd&rb.mask]
+	a, b := n.a, n.b
+	n.a, n.b = nil, nil
+	var size int64
+	if rb.sizes != nil {
+		// Read before the slot is freed for the producer to reuse.
+		size = rb.sizes[rd&rb.mask]
+	}
+	atomic.StoreUint64(&rb.read, rd+1) // cache coherence traffic.
+	return a, b, size, nil
+}
+
+// PutPair adds the provided pair to the queue.  If the queue is full, this
+// call will block until a pair is removed from the queue or Dispose is called
+// on the queue.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer) PutPair(a, b interface{}) error {
+	_, err := rb.put(a, b, 0, false)
+	return err
+}
+
+// OfferPair adds the provided pair to the queue if there is space.  If the
+// queue is full, this call will return false.  An error will be returned if
+// the queue is disposed.
+func (rb *RingBuffer) OfferPair(a, b interface{}) (bool, error) {
+	return rb.put(a, b, 0, true)
+}
+
+func (rb *RingBuffer) put(a, b interface{}, size int64, offer bool) (bool, error) {
+	wr := atomic.LoadUint64(&rb.write)
+	for {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return false, ErrDisposed
+		}
+		rd := atomic.LoadUint64(&rb.read)
+		// Not full.
+		if wr-rd < rb.Cap() {
+			break
+		}
+		if offer {
+			return false, nil
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	n := &rb.nodes[wr&rb.mask]
+	n.a, n.b = a, b
+	if rb.sizes != nil {
+		rb.sizes[wr&rb.mask] = size
+	}
+	atomic.StoreUint64(&rb.write, wr+1) // cache coherence traffic.
+	return true, nil
+}
@@ -0,0 +1,188 @@
+package pair_spsc
+
+import (
+	"context"
+	"lockfree/spsc"
+	"testing"
+	"time"
+)
+
+func TestPair(t *testing.T) {
+	const numItems = 1_000
+	q := NewRingBuffer(16)
+
+	go func() {
+		for i := 0; i < numItems; i++ {
+			q.PutPair(i, -i)
+		}
+	}()
+
+	for i := 0; i < numItems; i++ {
+		a, b, err := q.GetPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != i || b != -i {
+			t.Fatalf("expected (%d, %d), got (%v, %v)", i, -i, a, b)
+		}
+	}
+}
+
+func TestOfferPairFull(t *testing.T) {
+	q := NewRingBuffer(2)
+	for i := 0; i < 2; i++ {
+		if ok, err := q.OfferPair(i, i); !ok || err != nil {
+			t.Fatalf("expected offer to succeed, got %v, %v", ok, err)
+		}
+	}
+	if ok, _ := q.OfferPair(`a`, `b`); ok {
+		t.Fatal("expected offer on a full queue to fail")
+	}
+}
+
+type pair struct {
+	a, b interface{}
+}
+
+func BenchmarkSPSCStructPointer(b *testing.B) {
+	q := spsc.NewRingBuffer(8192)
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		q.Put(&pair{`a`, `b`})
+	}
+}
+
+func BenchmarkPairSPSC(b *testing.B) {
+	q := NewRingBuffer(8192)
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.GetPair()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		q.PutPair(`a`, `b`)
+	}
+}
+
+func TestContextQueue(t *testing.T) {
+	q := NewContextQueue(16)
+	live := context.Background()
+	var cancels []context.CancelFunc
+	for i := 0; i < 10; i++ {
+		ctx := live
+		if i%3 == 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(live)
+			cancels = append(cancels, cancel)
+		}
+		q.Put(ctx, i)
+	}
+	// Items 0, 3, 6 and 9 are abandoned while queued.
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	for _, want := range []int{1, 2, 4, 5, 7, 8} {
+		ctx, item, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item != want || ctx.Err() != nil {
+			t.Fatalf("expected %d with a live context, got %v, %v", want, item, ctx.Err())
+		}
+	}
+	if n := q.Cancelled(); n != 3 {
+		t.Fatalf("expected 3 cancelled items skipped so far, got %d", n)
+	}
+
+	q.Dispose()
+	if _, _, err := q.Get(); err == nil {
+		t.Fatal("expected Get on a disposed queue to fail")
+	}
+}
+
+func TestContextQueueNilContext(t *testing.T) {
+	q := NewContextQueue(4)
+	if err := q.Put(nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, item, err := q.Get()
+	if err != nil || item != 1 || ctx != context.Background() {
+		t.Fatalf("expected 1 with a background context, got %v, %v, %v", item, ctx, err)
+	}
+}
+
+func TestMemoryBoundedQueue(t *testing.T) {
+	q := NewMemoryBoundedQueue(8, 100)
+
+	// The byte budget binds first.
+	q.Put("large", 90)
+	if ok, _ := q.Offer("small", 20); ok {
+		t.Fatal("expected an offer exceeding the budget to fail")
+	}
+	put := make(chan struct{})
+	go func() {
+		q.Put("small", 20)
+		close(put)
+	}()
+	select {
+	case <-put:
+		t.Fatal("expected a put exceeding the budget to block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if item, _ := q.Get(); item != "large" {
+		t.Fatalf("expected large, got %v", item)
+	}
+	<-put
+	if b := q.Bytes(); b != 20 {
+		t.Fatalf("expected 20 bytes in flight, got %d", b)
+	}
+	q.Get()
+
+	// The item count binds first.
+	for i := 0; i < 8; i++ {
+		q.Put(i, 1)
+	}
+	if ok, _ := q.Offer(8, 1); ok {
+		t.Fatal("expected an offer to a full queue to fail")
+	}
+	if b := q.Bytes(); b != 8 {
+		t.Fatalf("expected a failed offer to release its bytes, got %d in flight", b)
+	}
+	for i := 0; i < 8; i++ {
+		q.Get()
+	}
+
+	// An item larger than the budget still fits in an empty queue.
+	if ok, _ := q.Offer("huge", 200); !ok {
+		t.Fatal("expected an item over the budget to fit in an empty queue")
+	}
+	q.Dispose()
+	if err := q.Put("late", 1); err == nil {
+		t.Fatal("expected Put on a disposed queue to fail")
+	}
+}
+
+// TestMemoryBoundedQueueAllocs checks that the sizes are kept without
+// boxing them, so that a put and a get of a pointer allocate nothing.
+func TestMemoryBoundedQueueAllocs(t *testing.T) {
+	q := NewMemoryBoundedQueue(8, 1<<20)
+	item := &struct{}{}
+	allocs := testing.AllocsPerRun(1000, func() {
+		q.Put(item, 1000)
+		q.Get()
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations per put and get, got %v", allocs)
+	}
+}
@@ -0,0 +1,137 @@
+// Package pump moves items from an mpmc queue into an spsc queue at a
+// shaped rate.
+package pump
+
+import (
+	"errors"
+	"lockfree/mpmc"
+	"lockfree/spsc"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidRate is returned by NewPump for a rate that is not positive.
+var ErrInvalidRate = errors.New(`pump: rate must be positive`)
+
+// Stats is a snapshot of a Pump's counters.
+type Stats struct {
+	Moved uint64 // Items placed in the destination.
+}
+
+// Pump drains a source queue into a destination queue on its own goroutine,
+// moving at most rate items per second after an initial burst.  It is the
+// single producer of the destination.
+type Pump[T any] struct {
+	src   *mpmc.RingBuffer[T]
+	dst   *spsc.RingBuffer
+	rate  float64
+	burst float64
+
+	moved    uint64 // Shared.
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewPump starts a Pump moving items from src to dst at no more than rate
+// items per second, letting up to burst items through at once after an
+// idle spell.  The pump stops by itself once either queue is disposed; if
+// the destination is disposed while the pump holds an item, that item is
+// dropped.  ErrInvalidRate is returned if rate is not positive.
+func NewPump[T any](src *mpmc.RingBuffer[T], dst *spsc.RingBuffer, rate float64, burst int) (*Pump[T], error) {
+	if !(rate > 0) {
+		return nil, ErrInvalidRate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	p := &Pump[T]{
+		src:   src,
+		dst:   dst,
+		rate:  rate,
+		burst: float64(burst),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *Pump[T]) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pump[T]) run() {
+	defer close(p.done)
+	tokens := p.burst
+	last := time.Now()
+	for !p.stopped() {
+		// Token bucket.
+		now := time.Now()
+		tokens += now.Sub(last).Seconds() * p.rate
+		last = now
+		if tokens > p.burst {
+			tokens = p.burst
+		}
+		if tokens < 1 {
+			wait := time.Duration((1 - tokens) / p.rate * float64(time.Second))
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		// Checked before taking an item, so that an idle pump notices a
+		// disposed destination too, and no item is taken just to be dropped.
+		if p.dst.IsDisposed() {
+			return
+		}
+		item, ok, err := p.src.TryGet()
+		if err != nil {
+			return
+		}
+		if !ok {
+			runtime.Gosched() // free up the cpu before the next iteration
+			continue
+		}
+		for {
+			ok, err := p.dst.Offer(item)
+			if err != nil {
+				return
+			}
+			if ok {
+				break
+			}
+			if p.stopped() {
+				// The item in hand is dropped.
+				return
+			}
+			runtime.Gosched() // free up the cpu before the next iteration
+		}
+		tokens--
+		atomic.AddUint64(&p.moved, 1)
+	}
+}
+
+// Stop stops the pump and waits for its goroutine to exit.  If the
+// destination is full at that point, the item the pump holds is dropped.
+// Neither queue is disposed.  Stop may be called more than once, and
+// concurrently.
+func (p *Pump[T]) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+// Stats returns the pump's counters so far.
+func (p *Pump[T]) Stats() Stats {
+	return Stats{Moved: atomic.LoadUint64(&p.moved)}
+}
@@ -0,0 +1,84 @@
+package pump
+
+import (
+	"lockfree/mpmc"
+	"lockfree/spsc"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPumpRate(t *testing.T) {
+	src := mpmc.NewRingBuffer[interface{}](256)
+	dst := spsc.NewRingBuffer(256)
+	for i := 0; i < 110; i++ {
+		src.Put(i)
+	}
+
+	// A burst of 10, then 100 items at 1000/s: at least 100ms.
+	start := time.Now()
+	p, _ := NewPump(src, dst, 1000, 10)
+	for i := 0; i < 110; i++ {
+		if got, _ := dst.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	elapsed := time.Since(start)
+	p.Stop()
+
+	if elapsed < 90*time.Millisecond {
+		t.Fatalf("expected the transfer to take about 100ms, took %v", elapsed)
+	}
+	if moved := p.Stats().Moved; moved != 110 {
+		t.Fatalf("expected 110 items moved, got %d", moved)
+	}
+}
+
+func TestPumpStopsOnDispose(t *testing.T) {
+	src := mpmc.NewRingBuffer[interface{}](8)
+	dst := spsc.NewRingBuffer(8)
+	p, _ := NewPump(src, dst, 1e6, 1)
+	src.Dispose()
+	select {
+	case <-p.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the pump to stop once its source is disposed")
+	}
+	p.Stop()
+}
+
+func TestPumpStopsOnDestinationDispose(t *testing.T) {
+	src := mpmc.NewRingBuffer[interface{}](8)
+	dst := spsc.NewRingBuffer(8)
+	p, _ := NewPump(src, dst, 1e6, 1)
+	dst.Dispose()
+	select {
+	case <-p.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected an idle pump to stop once its destination is disposed")
+	}
+	p.Stop()
+}
+
+func TestPumpConcurrentStop(t *testing.T) {
+	p, _ := NewPump(mpmc.NewRingBuffer[interface{}](8), spsc.NewRingBuffer(8), 1e6, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPumpInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		p, err := NewPump(mpmc.NewRingBuffer[interface{}](8), spsc.NewRingBuffer(8), rate, 1)
+		if p != nil || err != ErrInvalidRate {
+			t.Fatalf("rate %v: expected %v, got %v", rate, ErrInvalidRate, err)
+		}
+	}
+}
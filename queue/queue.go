@@ -0,0 +1,40 @@
+// Package queue describes the contract shared by the ring buffers in this
+// module.
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDisposed is returned by calls on a disposed queue, and ErrTimeout by
+// calls that timed out.  The ring buffers return these very values, so
+// errors.Is works whatever the implementation.
+var (
+	ErrDisposed = errors.New(`queue: closed`)
+	ErrTimeout  = errors.New(`queue: poll timed out`)
+)
+
+// Queue is the blocking surface implemented by every ring buffer.
+type Queue interface {
+	// Put adds item to the queue, blocking while it is full.
+	Put(item interface{}) error
+	// Get removes the next item from the queue, blocking while it is empty.
+	Get() (interface{}, error)
+	// Dispose closes the queue and unblocks pending Put and Get calls.
+	Dispose()
+	// IsDisposed reports whether Dispose has been called.
+	IsDisposed() bool
+	// Cap returns the capacity, rounded up to a power of 2.
+	Cap() uint64
+}
+
+// Poller is implemented by queues whose Get can time out.
+type Poller interface {
+	Poll(timeout time.Duration) (interface{}, error)
+}
+
+// Offerer is implemented by queues with a non-blocking Put.
+type Offerer interface {
+	Offer(item interface{}) (bool, error)
+}
@@ -0,0 +1,165 @@
+package queue_test
+
+import (
+	"errors"
+	"lockfree/bspsc"
+	"lockfree/cspsc"
+	"lockfree/dspsc"
+	"lockfree/mpmc"
+	"lockfree/mpsc"
+	"lockfree/queue"
+	"lockfree/sema_spsc"
+	"lockfree/spmc"
+	"lockfree/spsc"
+	"testing"
+	"time"
+)
+
+type impl struct {
+	name string
+	new  func(size uint64) queue.Queue
+	// skip maps a case name to the reason the implementation fails it.
+	skip map[string]string
+}
+
+var impls = []impl{
+	{name: "mpmc", new: func(size uint64) queue.Queue { return mpmc.NewRingBuffer[interface{}](size) }},
+	{name: "mpsc", new: func(size uint64) queue.Queue { return mpsc.NewRingBuffer[interface{}](size) }},
+	{name: "spmc", new: func(size uint64) queue.Queue { return spmc.NewRingBuffer[interface{}](size) }},
+	{name: "spsc", new: func(size uint64) queue.Queue { return spsc.NewRingBuffer(size) }},
+	{name: "bspsc", new: func(size uint64) queue.Queue { return bspsc.NewRingBuffer(size) }},
+	{name: "cspsc", new: func(size uint64) queue.Queue { return cspsc.NewRingBuffer(size) }},
+	{name: "dspsc", new: func(size uint64) queue.Queue { return dspsc.NewRingBuffer(size) }},
+	{
+		name: "sema_spsc",
+		new:  func(size uint64) queue.Queue { return sema_spsc.NewRingBuffer(size) },
+		skip: map[string]string{
+			"OfferOnFull": "Offer blocks like Put on a full queue",
+		},
+	},
+}
+
+var cases = []struct {
+	name string
+	run  func(t *testing.T, newQueue func(size uint64) queue.Queue)
+}{
+	{"FIFO", testFIFO},
+	{"DisposeUnblocksGet", testDisposeUnblocksGet},
+	{"OfferOnFull", testOfferOnFull},
+	{"PollTimeout", testPollTimeout},
+	{"CapRounding", testCapRounding},
+	{"GetAfterDispose", testGetAfterDispose},
+}
+
+// TestConformance runs every implementation through the same battery of
+// cases documenting the shared contract.
+func TestConformance(t *testing.T) {
+	for _, im := range impls {
+		im := im
+		t.Run(im.name, func(t *testing.T) {
+			for _, c := range cases {
+				c := c
+				t.Run(c.name, func(t *testing.T) {
+					if reason, ok := im.skip[c.name]; ok {
+						t.Skip(reason)
+					}
+					c.run(t, im.new)
+				})
+			}
+		})
+	}
+}
+
+func testFIFO(t *testing.T, newQueue func(size uint64) queue.Queue) {
+	const numItems = 1_000
+	q := newQueue(16)
+
+	go func() {
+		for i := 0; i < numItems; i++ {
+			q.Put(i)
+		}
+	}()
+
+	for i := 0; i < numItems; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+}
+
+func testDisposeUnblocksGet(t *testing.T, newQueue func(size uint64) queue.Queue) {
+	q := newQueue(16)
+
+	errs := make(chan error)
+	go func() {
+		_, err := q.Get()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Dispose()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error from Get after dispose")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected dispose to unblock Get")
+	}
+}
+
+func testOfferOnFull(t *testing.T, newQueue func(size uint64) queue.Queue) {
+	q := newQueue(4)
+	o, ok := q.(queue.Offerer)
+	if !ok {
+		t.Skip("no Offer method")
+	}
+
+	for i := uint64(0); i < q.Cap(); i++ {
+		if ok, err := o.Offer(i); !ok || err != nil {
+			t.Fatalf("expected offer %d to succeed, got %v, %v", i, ok, err)
+		}
+	}
+	if ok, err := o.Offer(`a`); ok || err != nil {
+		t.Fatalf("expected offer on a full queue to return false, got %v, %v", ok, err)
+	}
+}
+
+func testPollTimeout(t *testing.T, newQueue func(size uint64) queue.Queue) {
+	q := newQueue(4)
+	p, ok := q.(queue.Poller)
+	if !ok {
+		t.Skip("no Poll method")
+	}
+
+	if _, err := p.Poll(time.Millisecond); !errors.Is(err, queue.ErrTimeout) {
+		t.Fatalf("expected poll on an empty queue to time out, got %v", err)
+	}
+}
+
+func testCapRounding(t *testing.T, newQueue func(size uint64) queue.Queue) {
+	if c := newQueue(1000).Cap(); c != 1024 {
+		t.Fatalf("expected capacity 1024, got %d", c)
+	}
+	if c := newQueue(1024).Cap(); c != 1024 {
+		t.Fatalf("expected capacity 1024, got %d", c)
+	}
+}
+
+func testGetAfterDispose(t *testing.T, newQueue func(size uint64) queue.Queue) {
+	q := newQueue(4)
+	q.Dispose()
+	if !q.IsDisposed() {
+		t.Fatal("expected queue to be disposed")
+	}
+	if _, err := q.Get(); !errors.Is(err, queue.ErrDisposed) {
+		t.Fatalf("expected ErrDisposed from Get after dispose, got %v", err)
+	}
+	if err := q.Put(`a`); !errors.Is(err, queue.ErrDisposed) {
+		t.Fatalf("expected ErrDisposed from Put after dispose, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package sema_spsc
+
+import "sync/atomic"
+
+// counters returns the number of items put, got, and ready in the queue.
+func (rb *RingBuffer) counters() (put, got, length uint64) {
+	for i := range rb.nodes {
+		if n := atomic.LoadInt32(&rb.nodes[i].semaRd); n > 0 {
+			length += uint64(n)
+		}
+	}
+	return rb.write, rb.read, length
+}
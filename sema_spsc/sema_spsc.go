@@ -1,10 +1,13 @@
 package sema_spsc
 
 import (
-	"errors"
+	"lockfree/queue"
 	"sync/atomic"
 )
 
+// Errors of package queue.
+var ErrDisposed = queue.ErrDisposed
+
 // roundUp takes a uint64 greater than 0 and rounds it up to the next
 // power of 2.
 func roundUp(v uint64) uint64 {
@@ -42,8 +45,18 @@ type RingBuffer struct {
 	disposed uint64
 	_        [8]uint64
 	nodes    nodes
+
+	// done is closed by Dispose to wake the goroutines parked on a node.
+	done chan struct{}
+
+	// wakeups times how long a parked goroutine takes to resume once
+	// signaled. Nil unless the queue was created with WithWakeupLatency.
+	wakeups *wakeups
 }
 
+// Option configures a RingBuffer at construction time.
+type Option func(rb *RingBuffer)
+
 func (rb *RingBuffer) init(size uint64) {
 	size = roundUp(size)
 	rb.mask = size - 1 // so we don't have to do this with every put/get operation
@@ -56,9 +69,15 @@ func (rb *RingBuffer) init(size uint64) {
 
 // NewRingBuffer will allocate, initialize, and return a ring buffer
 // with the specified size.
-func NewRingBuffer(size uint64) *RingBuffer {
-	rb := &RingBuffer{}
+func NewRingBuffer(size uint64, opts ...Option) *RingBuffer {
+	rb := &RingBuffer{done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(rb)
+	}
 	rb.init(size)
+	if rb.wakeups != nil {
+		rb.wakeups.signaled = make([]int64, len(rb.nodes))
+	}
 	return rb
 }
 
@@ -66,7 +85,30 @@ func NewRingBuffer(size uint64) *RingBuffer {
 // in the Put and/or Get methods.  Calling those methods on a disposed
 // queue will return an error.
 func (rb *RingBuffer) Dispose() {
-	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+	if atomic.CompareAndSwapUint64(&rb.disposed, 0, 1) {
+		close(rb.done)
+	}
+}
+
+// park sleeps on n until the other goroutine signals it, and reports false
+// if the queue was disposed instead.
+func (rb *RingBuffer) park(n *node) bool {
+	select {
+	case <-n.ch:
+		return true
+	case <-rb.done:
+		return false
+	}
+}
+
+// wake signals the goroutine parked on n.  Once the queue is disposed, the
+// goroutine may have left without consuming an earlier signal, so the send
+// gives up rather than block on the full channel.
+func (rb *RingBuffer) wake(n *node) {
+	select {
+	case n.ch <- struct{}{}:
+	case <-rb.done:
+	}
 }
 
 // IsDisposed will return a bool indicating if this queue has been
@@ -88,13 +130,17 @@ func (rb *RingBuffer) Len() uint64 {
 func (rb *RingBuffer) Get() (interface{}, error) {
 	n := &rb.nodes[rb.read&rb.mask]
 	if atomic.LoadUint64(&rb.disposed) == 1 {
-		return nil, errors.New(`queue: closed`)
+		return nil, ErrDisposed
 	}
 
 	// Semaphore wait.
 	rd := atomic.AddInt32(&n.semaRd, -1) // cache coherence traffic
 	if rd < 0 {
-		<-n.ch // queue is empty, sleep now
+		// queue is empty, sleep now
+		if !rb.park(n) {
+			return nil, ErrDisposed
+		}
+		rb.resumed(rb.read)
 	}
 
 	rb.read++
@@ -103,7 +149,8 @@ func (rb *RingBuffer) Get() (interface{}, error) {
 	// Semaphore signal.
 	wr := atomic.AddInt32(&n.semaWr, 1) // cache coherence traffic
 	if wr < 1 {
-		n.ch <- struct{}{} // queue was full, wake up other goroutine
+		rb.signal(rb.read - 1)
+		rb.wake(n) // queue was full, wake up other goroutine
 	}
 
 	return data, nil
@@ -127,13 +174,17 @@ func (rb *RingBuffer) Offer(item interface{}) (bool, error) {
 func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
 	n := &rb.nodes[rb.write&rb.mask]
 	if atomic.LoadUint64(&rb.disposed) == 1 {
-		return false, errors.New(`queue: closed`)
+		return false, ErrDisposed
 	}
 
 	// Semaphore wait.
 	wr := atomic.AddInt32(&n.semaWr, -1) // cache coherence traffic
 	if wr < 0 {
-		<-n.ch // queue is full, sleep now
+		// queue is full, sleep now
+		if !rb.park(n) {
+			return false, ErrDisposed
+		}
+		rb.resumed(rb.write)
 	}
 
 	rb.write++
@@ -142,7 +193,8 @@ func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
 	// Semaphore signal.
 	rd := atomic.AddInt32(&n.semaRd, 1) // cache coherence traffic
 	if rd < 1 {
-		n.ch <- struct{}{} // queue was empty, wake up other goroutine
+		rb.signal(rb.write - 1)
+		rb.wake(n) // queue was empty, wake up other goroutine
 	}
 
 	return true, nil
@@ -2,8 +2,10 @@ package sema_spsc
 
 import (
 	"fmt"
+	"lockfree/internal/queuetest"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestSemaSPSC(t *testing.T) {
@@ -66,3 +68,103 @@ func BenchmarkDSPSC(b *testing.B) {
 
 	}
 }
+
+// TestNodeSize documents the size of a node on 64-bit platforms: the semaphores
+// are padded on both sides, so nodes never falsely share them.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node{}); size != 160 {
+		t.Fatalf("expected a 160 byte node, got %d", size)
+	}
+}
+
+func TestWakeupLatency(t *testing.T) {
+	q := NewRingBuffer(2, WithWakeupLatency())
+
+	// Parks the consumer on an empty queue, then the producer on a full one.
+	got := make(chan interface{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			item, _ := q.Get()
+			got <- item
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Put(0)
+	if item := <-got; item != 0 {
+		t.Fatalf("expected 0, got %v", item)
+	}
+	if l := q.WakeupLatency(); l.Count != 1 || l.Max <= 0 || l.Mean() != l.Total {
+		t.Fatalf("expected one consumer wakeup, got %+v", l)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i < 5; i++ {
+			q.Put(i)
+		}
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i < 5; i++ {
+		if item := <-got; item != i {
+			t.Fatalf("expected %d, got %v", i, item)
+		}
+	}
+	<-done
+	l := q.WakeupLatency()
+	if l.Count < 2 || l.Max <= 0 || l.Mean() <= 0 || l.Mean() > l.Max {
+		t.Fatalf("expected a producer wakeup too, got %+v", l)
+	}
+	t.Logf("%d wakeups, mean %v, max %v", l.Count, l.Mean(), l.Max)
+
+	if l := NewRingBuffer(2).WakeupLatency(); l != (WakeupLatency{}) {
+		t.Fatalf("expected no measurements without WithWakeupLatency, got %+v", l)
+	}
+}
+
+func TestConservation(t *testing.T) {
+	q := NewRingBuffer(512)
+	queuetest.Conservation(t, q, q.counters)
+}
+
+func TestDisposeUnblocks(t *testing.T) {
+	// A consumer parked on an empty queue.
+	q := NewRingBuffer(4)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := q.Get()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Dispose()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected Get to fail once the queue is disposed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Dispose to wake the consumer")
+	}
+
+	// A producer parked on a full queue.
+	q = NewRingBuffer(4)
+	for i := 0; i < 4; i++ {
+		q.Put(i)
+	}
+	go func() {
+		errs <- q.Put(4)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Dispose()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected Put to fail once the queue is disposed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Dispose to wake the producer")
+	}
+}
@@ -0,0 +1,84 @@
+package sema_spsc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WakeupLatency summarizes how long parked goroutines took to resume after
+// being signaled, which is the scheduling cost of parking instead of
+// spinning.
+type WakeupLatency struct {
+	Count uint64        // Wakeups measured.
+	Total time.Duration // Sum of their latencies.
+	Max   time.Duration
+}
+
+// Mean returns the average wakeup latency, or 0 if there was no wakeup.
+func (l WakeupLatency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+// wakeups accumulates wakeup latencies from both sides of the queue.
+type wakeups struct {
+	// signaled holds, per node, when the goroutine parked on it was last
+	// signaled.  The channel send orders its write before the read of the
+	// woken goroutine.
+	signaled []int64
+
+	count uint64 // Shared.
+	total int64  // Shared.
+	max   int64  // Shared.
+}
+
+// WithWakeupLatency makes the queue time every wakeup of a parked Get or
+// Put, from the signal of the other side to the parked call resuming, which
+// WakeupLatency reports.  It costs each wakeup two clock reads.
+func WithWakeupLatency() Option {
+	return func(rb *RingBuffer) {
+		rb.wakeups = &wakeups{}
+	}
+}
+
+// signal stamps the time the goroutine parked on the node of sequence seq is
+// woken up.
+func (rb *RingBuffer) signal(seq uint64) {
+	if rb.wakeups != nil {
+		rb.wakeups.signaled[seq&rb.mask] = time.Now().UnixNano()
+	}
+}
+
+// resumed records the wakeup latency of a goroutine parked on the node of
+// sequence seq.
+func (rb *RingBuffer) resumed(seq uint64) {
+	w := rb.wakeups
+	if w == nil {
+		return
+	}
+	d := time.Now().UnixNano() - w.signaled[seq&rb.mask]
+	atomic.AddUint64(&w.count, 1)
+	atomic.AddInt64(&w.total, d)
+	for {
+		max := atomic.LoadInt64(&w.max)
+		if d <= max || atomic.CompareAndSwapInt64(&w.max, max, d) {
+			return
+		}
+	}
+}
+
+// WakeupLatency returns a summary of the wakeup latencies measured so far,
+// or a zero summary if the queue was not created with WithWakeupLatency.
+func (rb *RingBuffer) WakeupLatency() WakeupLatency {
+	w := rb.wakeups
+	if w == nil {
+		return WakeupLatency{}
+	}
+	return WakeupLatency{
+		Count: atomic.LoadUint64(&w.count),
+		Total: time.Duration(atomic.LoadInt64(&w.total)),
+		Max:   time.Duration(atomic.LoadInt64(&w.max)),
+	}
+}
@@ -0,0 +1,191 @@
+package split_mpmc
+
+import (
+	"lockfree/queue"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// minSize is 2 because size of 1 is invalid: a slot's sequence
+// uses index+1 as a flag to let consumers know data is ready to be
+// read, this breaks when size is set to 1.
+const minSize = 2
+
+// roundUp takes a uint64 greater than 0 and rounds it up to the next
+// power of 2.
+func roundUp(v uint64) uint64 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+// RingBuffer is a MPMC lockfree queue of T, using the protocol of Dmitry's
+// bounded mpmc queue, but storing the slots' sequences and data in two
+// parallel arrays rather than one array of nodes.  Producers and consumers
+// spinning on a sequence then only pull sequences into their cache, 8 per
+// line, and touch a slot's data once they claimed it.
+type RingBuffer[T any] struct {
+	_        [8]uint64
+	write    uint64 // Shared only with producers.
+	_        [8]uint64
+	read     uint64 // Shared only with consumers.
+	_        [8]uint64
+	mask     uint64
+	disposed uint64
+	_        [8]uint64
+	seqs     []uint64 // Shared.
+	data     []T
+}
+
+func (rb *RingBuffer[T]) init(size uint64) {
+	size = roundUp(size)
+	rb.seqs = make([]uint64, size)
+	rb.data = make([]T, size)
+	for i := range rb.seqs {
+		rb.seqs[i] = uint64(i)
+	}
+	rb.mask = size - 1 // so we don't have to do this with every put/get operation
+}
+
+// NewRingBuffer will allocate, initialize, and return a ring buffer
+// with the specified size.
+func NewRingBuffer[T any](size uint64) *RingBuffer[T] {
+	rb := &RingBuffer[T]{}
+	if size < minSize {
+		size = minSize
+	}
+	rb.init(size)
+	return rb
+}
+
+// Dispose will dispose of this queue and free any blocked threads
+// in the Put and/or Get methods.  Calling those methods on a disposed
+// queue will return an error.
+func (rb *RingBuffer[T]) Dispose() {
+	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this queue has been
+// disposed.
+func (rb *RingBuffer[T]) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *RingBuffer[T]) Cap() uint64 {
+	return uint64(len(rb.seqs))
+}
+
+// Get will return the next item in the queue.  This call will block
+// if the queue is empty.  This call will unblock when an item is added
+// to the queue or Dispose is called on the queue.  An error will be returned
+// if the queue is disposed.
+func (rb *RingBuffer[T]) Get() (T, error) {
+	return rb.Poll(0)
+}
+
+// Poll will return the next item in the queue.  This call will block
+// if the queue is empty.  This call will unblock when an item is added
+// to the queue, Dispose is called on the queue, or the timeout is reached. An
+// error will be returned if the queue is disposed or a timeout occurs. A
+// non-positive timeout will block indefinitely.  The zero value of T is
+// returned along with an error.
+func (rb *RingBuffer[T]) Poll(timeout time.Duration) (T, error) {
+	var (
+		zero  T
+		pos   = atomic.LoadUint64(&rb.read)
+		start time.Time
+	)
+	if timeout > 0 {
+		start = time.Now()
+	}
+L:
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return zero, ErrDisposed
+		}
+
+		seq := atomic.LoadUint64(&rb.seqs[pos&rb.mask])
+		switch dif := int64(seq - (pos + 1)); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&rb.read, pos, pos+1) {
+				break L
+			}
+		case dif < 0:
+			// Empty: no producer has filled this slot yet.
+		default:
+			pos = atomic.LoadUint64(&rb.read)
+		}
+
+		if timeout > 0 && time.Since(start) >= timeout {
+			return zero, ErrTimeout
+		}
+
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	i := pos & rb.mask
+	data := rb.data[i]
+	rb.data[i] = zero
+	atomic.StoreUint64(&rb.seqs[i], pos+rb.mask+1) // cache coherence traffic
+	return data, nil
+}
+
+// Put adds the provided item to the queue.  If the queue is full, this
+// call will block until an item is added to the queue or Dispose is called
+// on the queue.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer[T]) Put(item T) error {
+	_, err := rb.put(item, false)
+	return err
+}
+
+// Offer adds the provided item to the queue if there is space.  If the queue
+// is full, this call will return false.  An error will be returned if the
+// queue is disposed.
+func (rb *RingBuffer[T]) Offer(item T) (bool, error) {
+	return rb.put(item, true)
+}
+
+func (rb *RingBuffer[T]) put(item T, offer bool) (bool, error) {
+	pos := atomic.LoadUint64(&rb.write)
+L:
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return false, ErrDisposed
+		}
+
+		seq := atomic.LoadUint64(&rb.seqs[pos&rb.mask])
+		switch dif := int64(seq - pos); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&rb.write, pos, pos+1) {
+				break L
+			}
+		case dif < 0:
+			// Full: no consumer has freed this slot yet.
+			if offer {
+				return false, nil
+			}
+		default:
+			pos = atomic.LoadUint64(&rb.write)
+		}
+
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+
+	i := pos & rb.mask
+	rb.data[i] = item
+	atomic.StoreUint64(&rb.seqs[i], pos+1) // cache coherence traffic
+	return true, nil
+}
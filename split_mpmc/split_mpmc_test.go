@@ -0,0 +1,177 @@
+package split_mpmc
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrent(t *testing.T) {
+	const numProducers, numConsumers, numItems = 4, 4, 10_000
+	q := NewRingBuffer[int](16)
+
+	var wg sync.WaitGroup
+	wg.Add(numProducers)
+	for p := 0; p < numProducers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < numItems; i++ {
+				q.Put(p*numItems + i)
+			}
+		}(p)
+	}
+
+	var got sync.Map
+	var count int64
+	var cwg sync.WaitGroup
+	cwg.Add(numConsumers)
+	for c := 0; c < numConsumers; c++ {
+		go func() {
+			defer cwg.Done()
+			for atomic.LoadInt64(&count) < numProducers*numItems {
+				item, err := q.Poll(time.Millisecond)
+				if err != nil {
+					continue
+				}
+				if _, dup := got.LoadOrStore(item, true); dup {
+					t.Errorf("expected %d once, got it twice", item)
+				}
+				atomic.AddInt64(&count, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	cwg.Wait()
+	if count != numProducers*numItems {
+		t.Fatalf("expected %d items, got %d", numProducers*numItems, count)
+	}
+}
+
+func TestOfferAndDispose(t *testing.T) {
+	q := NewRingBuffer[string](2)
+	for _, s := range []string{"a", "b"} {
+		if ok, err := q.Offer(s); !ok || err != nil {
+			t.Fatalf("expected %s to be accepted, got %v, %v", s, ok, err)
+		}
+	}
+	if ok, _ := q.Offer("c"); ok {
+		t.Fatal("expected an offer to a full queue to fail")
+	}
+	if item, _ := q.Get(); item != "a" {
+		t.Fatalf("expected a, got %v", item)
+	}
+	if q.data[0] != "" {
+		t.Fatal("expected the slot to be cleared once consumed")
+	}
+
+	q.Dispose()
+	if item, err := q.Get(); err == nil || item != "" {
+		t.Fatalf("expected the zero value and an error on a disposed queue, got %q, %v", item, err)
+	}
+}
+
+// payload is a cache line of data.
+type payload [8]uint64
+
+// interleaved is the same queue with a node per slot holding both the
+// sequence and the data, as in mpmc, to benchmark the layouts against each
+// other.
+type interleaved[T any] struct {
+	_     [8]uint64
+	write uint64
+	_     [8]uint64
+	read  uint64
+	_     [8]uint64
+	mask  uint64
+	nodes []struct {
+		position uint64
+		data     T
+	}
+}
+
+func newInterleaved[T any](size uint64) *interleaved[T] {
+	rb := &interleaved[T]{mask: size - 1}
+	rb.nodes = make([]struct {
+		position uint64
+		data     T
+	}, size)
+	for i := range rb.nodes {
+		rb.nodes[i].position = uint64(i)
+	}
+	return rb
+}
+
+func (rb *interleaved[T]) Put(item T) error {
+	pos := atomic.LoadUint64(&rb.write)
+	for {
+		n := &rb.nodes[pos&rb.mask]
+		if dif := int64(atomic.LoadUint64(&n.position) - pos); dif == 0 {
+			if atomic.CompareAndSwapUint64(&rb.write, pos, pos+1) {
+				n.data = item
+				atomic.StoreUint64(&n.position, pos+1)
+				return nil
+			}
+		} else if dif > 0 {
+			pos = atomic.LoadUint64(&rb.write)
+		}
+		runtime.Gosched()
+	}
+}
+
+func (rb *interleaved[T]) Get() (T, error) {
+	pos := atomic.LoadUint64(&rb.read)
+	for {
+		n := &rb.nodes[pos&rb.mask]
+		if dif := int64(atomic.LoadUint64(&n.position) - (pos + 1)); dif == 0 {
+			if atomic.CompareAndSwapUint64(&rb.read, pos, pos+1) {
+				data := n.data
+				atomic.StoreUint64(&n.position, pos+rb.mask+1)
+				return data, nil
+			}
+		} else if dif > 0 {
+			pos = atomic.LoadUint64(&rb.read)
+		}
+		runtime.Gosched()
+	}
+}
+
+type payloadQueue interface {
+	Put(item payload) error
+	Get() (payload, error)
+}
+
+// benchmarkContended has 4 producers and 4 consumers moving b.N items.
+func benchmarkContended(b *testing.B, q payloadQueue) {
+	const workers = 4
+	var wg sync.WaitGroup
+	wg.Add(2 * workers)
+	for w := 0; w < workers; w++ {
+		n := b.N / workers
+		if w == 0 {
+			n += b.N % workers
+		}
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				q.Put(payload{uint64(i)})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				q.Get()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkSplit(b *testing.B) {
+	benchmarkContended(b, NewRingBuffer[payload](1024))
+}
+
+func BenchmarkInterleaved(b *testing.B) {
+	benchmarkContended(b, newInterleaved[payload](1024))
+}
@@ -0,0 +1,161 @@
+package spmc
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetAndOffer(t *testing.T) {
+	q := NewRingBuffer[int](2)
+	for i := 0; i < 2; i++ {
+		if ok, err := q.Offer(i); !ok || err != nil {
+			t.Fatalf("expected offer to succeed, got %v, %v", ok, err)
+		}
+	}
+	if ok, _ := q.Offer(2); ok {
+		t.Fatal("expected offer on a full queue to fail")
+	}
+	for i := 0; i < 2; i++ {
+		if got, _ := q.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if _, err := q.Poll(time.Millisecond); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+	q.Dispose()
+	if err := q.Put(0); err == nil {
+		t.Fatal("expected put on a disposed queue to fail")
+	}
+}
+
+// TestConsumers checks that every item is delivered to exactly one of the
+// competing consumers, and that each consumer sees items in order.
+func TestConsumers(t *testing.T) {
+	const (
+		consumers = 4
+		items     = 40000
+	)
+	q := NewRingBuffer[int](64)
+
+	var (
+		wg   sync.WaitGroup
+		got  = make([][]int, consumers)
+		errs = make(chan error, consumers)
+	)
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for {
+				item, err := q.Get()
+				if err != nil {
+					if err != ErrDisposed {
+						errs <- err
+					}
+					return
+				}
+				got[c] = append(got[c], item)
+			}
+		}(c)
+	}
+
+	for i := 0; i < items; i++ {
+		q.Put(i)
+	}
+	// Wait for the consumers to empty the queue before disposing of it.
+	for atomic.LoadUint64(&q.read) != items {
+		time.Sleep(time.Millisecond)
+	}
+	q.Dispose()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	seen := make([]bool, items)
+	for c, vs := range got {
+		for i, v := range vs {
+			if seen[v] {
+				t.Fatalf("item %d delivered twice", v)
+			}
+			seen[v] = true
+			if i > 0 && v <= vs[i-1] {
+				t.Fatalf("consumer %d got %d after %d", c, v, vs[i-1])
+			}
+		}
+	}
+	for v, ok := range seen {
+		if !ok {
+			t.Fatalf("item %d never delivered", v)
+		}
+	}
+}
+
+func BenchmarkSPMCConcurrentRead(b *testing.B) {
+	q := NewRingBuffer[string](8192)
+	defer q.Dispose()
+
+	b.ResetTimer()
+	// 1 Producer.
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if q.Put(`a`) != nil {
+				return
+			}
+		}
+	}()
+
+	// GOMAXPROCS Consumers.
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.Get()
+		}
+	})
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer[int](64)
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.write >= uint64(ops) {
+		t.Fatalf("expected the write sequence to have wrapped around, got %d", q.write)
+	}
+}
+
+func TestDisposeError(t *testing.T) {
+	errClosed := errors.New("closed")
+	q := NewRingBuffer[int](2, WithDisposeError(errClosed), WithPureSpin())
+	q.Dispose()
+	if _, err := q.Get(); err != errClosed {
+		t.Fatalf("expected %v, got %v", errClosed, err)
+	}
+	if err := q.Put(0); err != errClosed {
+		t.Fatalf("expected %v, got %v", errClosed, err)
+	}
+}
@@ -0,0 +1,58 @@
+package spsc
+
+// DoubleBufferedQueue hands a fixed set of reusable objects back and forth
+// between a producer and a consumer, for pipelines that must not generate
+// garbage.  Objects travel to the consumer on a full ring and come back on a
+// free ring, both SPSC, so there must be exactly one producer and one
+// consumer.
+type DoubleBufferedQueue struct {
+	full *RingBuffer // Producer to consumer.
+	free *RingBuffer // Consumer to producer.
+}
+
+// NewDoubleBufferedQueue creates a DoubleBufferedQueue of n objects, made
+// by calling factory n times, all of them free to start with.
+func NewDoubleBufferedQueue(n uint64, factory func() interface{}) *DoubleBufferedQueue {
+	q := &DoubleBufferedQueue{
+		full: NewRingBuffer(n),
+		free: NewRingBuffer(n),
+	}
+	for i := uint64(0); i < n; i++ {
+		q.free.Put(factory())
+	}
+	return q
+}
+
+// Acquire returns a free object for the producer to fill.  This call will
+// block until the consumer recycles an object if none is free.  An error
+// will be returned if the queue is disposed.
+func (q *DoubleBufferedQueue) Acquire() (interface{}, error) {
+	return q.free.Get()
+}
+
+// Publish hands an object obtained from Acquire to the consumer.  An error
+// will be returned if the queue is disposed.
+func (q *DoubleBufferedQueue) Publish(obj interface{}) error {
+	return q.full.Put(obj)
+}
+
+// Consume returns the next published object.  This call will block until
+// the producer publishes one.  An error will be returned if the queue is
+// disposed.
+func (q *DoubleBufferedQueue) Consume() (interface{}, error) {
+	return q.full.Get()
+}
+
+// Recycle gives an object obtained from Consume back to the producer, once
+// the consumer is done with it.  An error will be returned if the queue is
+// disposed.
+func (q *DoubleBufferedQueue) Recycle(obj interface{}) error {
+	return q.free.Put(obj)
+}
+
+// Dispose will dispose of this queue and free any blocked threads in its
+// methods.
+func (q *DoubleBufferedQueue) Dispose() {
+	q.full.Dispose()
+	q.free.Dispose()
+}
@@ -0,0 +1,18 @@
+package spsc
+
+import "sync/atomic"
+
+// fastForward moves the cursors of an empty queue to seq, as if seq items
+// had gone through it, to test sequences wrapping around.
+func (rb *RingBuffer) fastForward(seq uint64) {
+	rb.write, rb.read, rb.acked = seq, seq, seq
+	for i := range rb.nodes {
+		rb.nodes[i].cancelled = seq
+	}
+}
+
+// counters returns the number of items put, got, and in the queue.
+func (rb *RingBuffer) counters() (put, got, length uint64) {
+	wr, rd := atomic.LoadUint64(&rb.write), atomic.LoadUint64(&rb.read)
+	return wr, rd, wr - rd
+}
@@ -0,0 +1,63 @@
+package spsc
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// frameReader streams queued items as length-prefixed frames.
+type frameReader struct {
+	rb     *RingBuffer
+	encode func(interface{}) ([]byte, error)
+	buf    []byte // Unread part of the current frame.
+}
+
+// FrameReader returns an io.ReadCloser that dequeues items, encodes them
+// with encode and yields each as a frame: its length as a 4 byte big-endian
+// integer followed by the encoded bytes.  Frames are split across Read
+// calls as needed, so any buffer size works.  Once the queue is disposed,
+// the remaining items are drained and then Read returns io.EOF.  Close
+// disposes of the queue.  The reader is the single consumer while in use.
+func (rb *RingBuffer) FrameReader(encode func(interface{}) ([]byte, error)) io.ReadCloser {
+	return &frameReader{rb: rb, encode: encode}
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	if len(fr.buf) == 0 {
+		data, err := fr.next()
+		if err != nil {
+			return 0, err
+		}
+		b, err := fr.encode(data)
+		if err != nil {
+			return 0, err
+		}
+		frame := make([]byte, 4+len(b))
+		binary.BigEndian.PutUint32(frame, uint32(len(b)))
+		copy(frame[4:], b)
+		fr.buf = frame
+	}
+	n := copy(p, fr.buf)
+	fr.buf = fr.buf[n:]
+	return n, nil
+}
+
+// next gets the next item, draining what is left once the queue is
+// disposed.
+func (fr *frameReader) next() (interface{}, error) {
+	rb := fr.rb
+	data, err := rb.Get()
+	if err == nil || !rb.IsDisposed() {
+		return data, err
+	}
+	data, ok := rb.takeLeftover()
+	if !ok {
+		return nil, io.EOF
+	}
+	return data, nil
+}
+
+func (fr *frameReader) Close() error {
+	fr.rb.Dispose()
+	return nil
+}
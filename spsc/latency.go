@@ -0,0 +1,84 @@
+package spsc
+
+import (
+	"math/bits"
+	"time"
+)
+
+// latencySubBuckets is how many linear buckets each power of 2 of latency is
+// split into, which bounds the error of a percentile to 1/8th.
+const (
+	latencySubBits    = 3
+	latencySubBuckets = 1 << latencySubBits
+)
+
+// latency is a log-linear histogram of how long items sat in the queue, in
+// nanoseconds.  It is only touched by the consumer.
+type latency struct {
+	buckets [(64 - latencySubBits + 1) * latencySubBuckets]uint64
+	count   uint64
+}
+
+// WithLatencyHistogram makes the queue stamp every item with the time it was
+// enqueued and keep a histogram of how long items waited until the consumer
+// got them, which LatencyPercentiles summarizes.  It costs a clock read on
+// both sides of every item.
+func WithLatencyHistogram() Option {
+	return func(rb *RingBuffer) {
+		rb.latency = &latency{}
+		rb.stamp()
+	}
+}
+
+func latencyBucket(v uint64) int {
+	if v < latencySubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - latencySubBits - 1
+	return (shift+1)*latencySubBuckets + int(v>>shift) - latencySubBuckets
+}
+
+// latencyValue returns the lower bound of bucket i.
+func latencyValue(i int) uint64 {
+	if i < latencySubBuckets {
+		return uint64(i)
+	}
+	shift := i/latencySubBuckets - 1
+	return uint64(latencySubBuckets+i%latencySubBuckets) << shift
+}
+
+func (l *latency) record(stamp int64, now int64) {
+	d := now - stamp
+	if d < 0 {
+		d = 0
+	}
+	l.buckets[latencyBucket(uint64(d))]++
+	l.count++
+}
+
+func (l *latency) percentile(p float64) time.Duration {
+	rank := uint64(p * float64(l.count))
+	if rank >= l.count {
+		rank = l.count - 1
+	}
+	var seen uint64
+	for i, c := range l.buckets {
+		seen += c
+		if seen > rank {
+			return time.Duration(latencyValue(i))
+		}
+	}
+	return 0
+}
+
+// LatencyPercentiles returns the 50th, 90th and 99th percentiles of the time
+// items spent in the queue, to within 1/8th, for queues created with
+// WithLatencyHistogram.  It returns zeros if nothing was consumed yet.  Only
+// the single consumer may call LatencyPercentiles.
+func (rb *RingBuffer) LatencyPercentiles() (p50, p90, p99 time.Duration) {
+	l := rb.latency
+	if l == nil || l.count == 0 {
+		return 0, 0, 0
+	}
+	return l.percentile(0.50), l.percentile(0.90), l.percentile(0.99)
+}
@@ -0,0 +1,125 @@
+package spsc
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrFull is returned by Put on a full queue created with the Error policy,
+// and by PutDeduped, PutTracked and PutCancelable when the DropNewest policy
+// drops the item.
+var ErrFull = errors.New(`queue: full`)
+
+// ErrNotDropOldest is returned by PutOverwrite on a queue created without
+// the DropOldest policy.
+var ErrNotDropOldest = errors.New(`queue: overwrite without DropOldest policy`)
+
+// ErrDropOldest is returned by GetGrouped on a queue created with the
+// DropOldest policy.
+var ErrDropOldest = errors.New(`queue: grouped get with DropOldest policy`)
+
+// FullPolicy decides what Put does when the queue is full.
+type FullPolicy int
+
+const (
+	// Block waits for the consumer to make room. This is the default.
+	Block FullPolicy = iota
+	// DropNewest discards the item being put.
+	DropNewest
+	// DropOldest discards the item at the head of the queue to make room,
+	// so the consumer may find sequences missing.
+	DropOldest
+	// Error makes Put return ErrFull.
+	Error
+)
+
+// WithFullPolicy makes Put handle a full queue according to policy rather
+// than block.  Offer, OfferFor and PutWith are unaffected.  Dropped counts
+// the items the dropping policies discard.
+//
+// DropOldest has the producer take items off the head of the queue, so with
+// it the consumer claims every item with a CAS on the read cursor, and the
+// producer waits on the consumer to be done with a slot before reusing it.
+func WithFullPolicy(policy FullPolicy) Option {
+	return func(rb *RingBuffer) {
+		rb.policy = policy
+	}
+}
+
+// Dropped returns the number of items discarded by the DropNewest and
+// DropOldest policies.
+func (rb *RingBuffer) Dropped() uint64 {
+	return atomic.LoadUint64(&rb.dropped)
+}
+
+// claim takes item rd off the head of the queue for the consumer, and
+// returns false if the producer dropped it first.  The consumer must free
+// the item's slot once done with it.
+func (rb *RingBuffer) claim(rd uint64) bool {
+	if rb.policy != DropOldest {
+		return true
+	}
+	return atomic.CompareAndSwapUint64(&rb.read, rd, rd+1)
+}
+
+// free hands the slot of item rd, claimed and consumed, back to the
+// producer.
+func (rb *RingBuffer) free(rd uint64) {
+	if rb.policy != DropOldest {
+		atomic.StoreUint64(&rb.read, rd+1) // cache coherence traffic.
+		return
+	}
+	atomic.StoreUint64(&rb.nodes[rd&rb.mask].position, rd+rb.Cap())
+}
+
+// outcome is what a put did with its item.
+type outcome int
+
+const (
+	// refused: the queue was full and the put gave up, see Offer and PutWith.
+	refused outcome = iota
+	// written: the item was enqueued.
+	written
+	// deduped: the item repeats the previous one, see WithDedupAdjacent.
+	deduped
+	// dropped: the queue was full and the DropNewest policy discarded it.
+	dropped
+)
+
+// full applies the policy to a put of item wr on a full queue, whose head
+// is item rd.  It returns whether to retry the put, and otherwise the
+// result of the put.
+func (rb *RingBuffer) full(wr, rd uint64) (bool, outcome, error) {
+	switch rb.policy {
+	case DropNewest:
+		atomic.AddUint64(&rb.dropped, 1)
+		return false, dropped, nil
+	case DropOldest:
+		if atomic.CompareAndSwapUint64(&rb.read, rd, rd+1) {
+			rb.nodes[rd&rb.mask].data = nil
+			atomic.StoreUint64(&rb.nodes[rd&rb.mask].position, rd+rb.Cap())
+			atomic.AddUint64(&rb.dropped, 1)
+		}
+		return true, refused, nil
+	case Error:
+		return false, refused, ErrFull
+	}
+	return true, refused, nil
+}
+
+// PutOverwrite adds the provided item to the queue, overwriting the oldest
+// item if the queue is full, so that it holds the latest Cap items, e.g.
+// the freshest samples of a telemetry feed.  It never waits for the consumer
+// to make room, only for it to finish reading the slot being overwritten.
+// The consumer may then find sequences missing: the queue is lossy.  It
+// requires the queue to be created with WithFullPolicy(DropOldest), which
+// has the consumer claim every item with a CAS so that the producer can
+// take items off the head too, and returns ErrNotDropOldest otherwise.  An
+// error will be returned if the queue is disposed.
+func (rb *RingBuffer) PutOverwrite(item interface{}) error {
+	if rb.policy != DropOldest {
+		return ErrNotDropOldest
+	}
+	_, err := rb.put(item, false, nil)
+	return err
+}
@@ -1,12 +1,26 @@
 package spsc
 
 import (
+	"context"
 	"errors"
+	"lockfree/internal/pause"
+	"lockfree/queue"
+	"log"
+	"math"
 	"runtime"
+	"runtime/debug"
+	"runtime/trace"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
 // roundUp takes a uint64 greater than 0 and rounds it up to the next
 // power of 2.
 func roundUp(v uint64) uint64 {
@@ -22,12 +36,19 @@ func roundUp(v uint64) uint64 {
 }
 
 type node struct {
-	position uint64
-	data     interface{}
+	position  uint64 // Shared. Next sequence the slot is free for, with DropOldest.
+	cancelled uint64 // Shared. Sequence+1 of the latest cancelled item.
+	data      interface{}
 }
 
 type nodes []node
 
+// cause wraps a dispose error so that atomic.Value always stores the same
+// concrete type.
+type cause struct {
+	err error
+}
+
 type RingBuffer struct {
 	_        [8]uint64
 	write    uint64 // Shared, owned by producer.
@@ -38,22 +59,322 @@ type RingBuffer struct {
 	disposed uint64
 	_        [8]uint64
 	nodes    nodes
+
+	// requested is the size passed to NewRingBuffer, before roundUp.
+	requested uint64
+
+	disposeOnce sync.Once
+	cause       atomic.Value // cause, set before disposed.
+
+	// createdAt is the stack that created the queue. Only set when the
+	// queue was created with WithLeakDetection.
+	createdAt []byte
+
+	// validate rejects items before they are enqueued. Nil unless the
+	// queue was created with WithValidator.
+	validate func(interface{}) error
+
+	// encode and decode convert items to the bytes stored in the nodes and
+	// back. Nil unless the queue was created with WithCodec.
+	encode func(interface{}) ([]byte, error)
+	decode func([]byte) (interface{}, error)
+
+	// onEmpty is called by the consumer when a Get empties the queue. Nil
+	// unless the queue was created with WithOnEmpty.
+	onEmpty func()
+
+	// batch is the slice reused by PollBatchInternal.
+	batch []interface{}
+
+	// scrub wipes consumed items once the consumer is done with them, and
+	// consumed holds those not wiped yet. Nil unless the queue was created
+	// with WithSecureClear.
+	scrub    func(interface{})
+	consumed []interface{}
+
+	// onDispose is called once the queue is disposed. Nil unless the queue
+	// was created with WithOnDispose.
+	onDispose func()
+
+	// policy is what Put does on a full queue, see WithFullPolicy, and
+	// dropped counts the items it discarded.
+	policy  FullPolicy
+	dropped uint64 // Shared.
+
+	// disposeErr replaces the default error of calls on a disposed queue.
+	// Nil unless the queue was created with WithDisposeError.
+	disposeErr error
+
+	// timeoutCheckEvery is how many spins Poll does between clock reads.
+	timeoutCheckEvery uint64
+
+	// tracing marks blocking waits as runtime/trace regions. Only set when
+	// the queue was created with WithTracing.
+	tracing bool
+
+	// pureSpin makes the wait loops spin without yielding, and cpuPause
+	// with a spin-wait hint. Only set when the queue was created with
+	// WithPureSpin or WithCPUPause respectively.
+	pureSpin bool
+	cpuPause bool
+
+	// equal reports whether an item repeats the last one enqueued, which is
+	// kept in last and owned by the producer. Nil unless the queue was
+	// created with WithDedupAdjacent.
+	equal   func(a, b interface{}) bool
+	last    interface{}
+	hasLast bool
+
+	// acked is the sequence+1 of the latest item the consumer acknowledged
+	// with Ack.
+	acked uint64 // Shared.
+
+	// latency times items through the queue. Nil unless the queue was
+	// created with WithLatencyHistogram.
+	latency *latency
+
+	// ttl is how long items stay fresh, and expired counts the stale items
+	// skipped. Only set when the queue was created with WithTTL.
+	ttl     time.Duration
+	expired uint64 // Shared.
+
+	// stamps holds the enqueue time of the item in each node, for latency
+	// and ttl. Nil unless either is enabled.
+	stamps []int64
+}
+
+// defaultTimeoutCheckEvery is the default number of spins Poll does between
+// clock reads.
+const defaultTimeoutCheckEvery = 64
+
+// Option configures a RingBuffer at construction time.
+type Option func(rb *RingBuffer)
+
+// WithCodec makes the queue store items encoded: the enqueue methods encode
+// each item on the producer goroutine, and the get methods decode it on the
+// consumer goroutine, so that the two only share the encoding, and the
+// queue holds no reference into the producer's values.  An item encode
+// fails on is not enqueued and the error is returned.  An item decode fails
+// on is consumed, and the error is returned by the get, along with the
+// items taken before it by a batch get.  Reserve and Commit bypass the
+// codec: the reserved slot must be given the encoded bytes.
+func WithCodec(encode func(interface{}) ([]byte, error), decode func([]byte) (interface{}, error)) Option {
+	return func(rb *RingBuffer) {
+		rb.encode = encode
+		rb.decode = decode
+	}
+}
+
+// decoded returns item as enqueued, decoding it if the queue has a codec.
+func (rb *RingBuffer) decoded(item interface{}) (interface{}, error) {
+	if rb.decode == nil {
+		return item, nil
+	}
+	return rb.decode(item.([]byte))
+}
+
+// WithValidator makes Put, Offer and the other enqueue methods run validate
+// on the producer goroutine before placing each item.  If it returns an
+// error, the item is not enqueued and that error is returned.
+func WithValidator(validate func(interface{}) error) Option {
+	return func(rb *RingBuffer) {
+		rb.validate = validate
+	}
+}
+
+// WithOnEmpty calls onEmpty on the consumer goroutine whenever a Get leaves
+// the queue empty.  It fires once per transition to empty: Gets can't
+// observe an empty queue again until a Put refills it.
+func WithOnEmpty(onEmpty func()) Option {
+	return func(rb *RingBuffer) {
+		rb.onEmpty = onEmpty
+	}
+}
+
+// WithSecureClear makes the consumer pass every item it got to scrub once it
+// is done with it, which is when it comes back for more: at the start of the
+// next Get, Poll or PollBatchInternal call.  scrub can then wipe sensitive
+// payloads, e.g. zero a []byte, so they don't linger in memory.  Consumed
+// slots are always cleared, so the queue itself keeps no reference.  The
+// caller must not retain items past the next consumer call.
+func WithSecureClear(scrub func(interface{})) Option {
+	return func(rb *RingBuffer) {
+		rb.scrub = scrub
+	}
+}
+
+// release scrubs the items handed to the consumer by its previous call.
+func (rb *RingBuffer) release() {
+	for i, item := range rb.consumed {
+		rb.scrub(item)
+		rb.consumed[i] = nil
+	}
+	rb.consumed = rb.consumed[:0]
+}
+
+// leakLogf reports queues collected without Dispose. Tests replace it.
+var leakLogf = log.Printf
+
+// WithLeakDetection logs a warning, including the stack that created the
+// queue, if the queue is garbage collected without Dispose having been
+// called. It is a debugging aid and costs a stack capture per queue. Note a
+// goroutine still blocked in Get or Put keeps the queue reachable, so this
+// catches queues that were dropped, not goroutines that leaked with them.
+func WithLeakDetection() Option {
+	return func(rb *RingBuffer) {
+		rb.createdAt = debug.Stack()
+		runtime.SetFinalizer(rb, func(rb *RingBuffer) {
+			if !rb.IsDisposed() {
+				leakLogf("spsc: RingBuffer garbage collected without Dispose, created at:\n%s", rb.createdAt)
+			}
+		})
+	}
 }
 
 func (rb *RingBuffer) init(size uint64) {
+	rb.requested = size
 	size = roundUp(size)
 	rb.nodes = make(nodes, size)
 	for i := uint64(0); i < size; i++ {
 		rb.nodes[i] = node{position: i}
 	}
 	rb.mask = size - 1 // so we don't have to do this with every put/get operation
+	rb.timeoutCheckEvery = defaultTimeoutCheckEvery
+}
+
+// WithTimeoutCheckInterval makes Poll read the clock only every n spins of
+// its wait loop, 64 by default, rather than on every spin, as the clock read
+// would dominate a tight spin.  A timeout then fires up to n spins late.
+func WithTimeoutCheckInterval(n uint64) Option {
+	return func(rb *RingBuffer) {
+		if n == 0 {
+			n = 1
+		}
+		rb.timeoutCheckEvery = n
+	}
+}
+
+// WithPureSpin makes the wait loops of Get, Put and friends busy-wait
+// without calling runtime.Gosched, for a goroutine locked to a thread on an
+// isolated core, where yielding only invites the scheduler to run something
+// else there.  It trades a whole core for the lowest wake-up latency, and
+// without a core to spare it starves the other goroutines until the runtime
+// preempts the spinner.
+func WithPureSpin() Option {
+	return func(rb *RingBuffer) {
+		rb.pureSpin = true
+	}
+}
+
+// WithCPUPause is WithPureSpin executing the CPU's spin-wait hint, PAUSE on
+// amd64 and YIELD on arm64, on every iteration of the wait loops.  The
+// hint saves power and leaves more of the core to a hyperthread sibling,
+// at the cost of a slightly slower reaction.  It is a no-op on other
+// architectures.
+func WithCPUPause() Option {
+	return func(rb *RingBuffer) {
+		rb.pureSpin = true
+		rb.cpuPause = true
+	}
+}
+
+// spin is called on every iteration of a wait loop.
+func (rb *RingBuffer) spin() {
+	switch {
+	case rb.cpuPause:
+		pause.Pause()
+	case !rb.pureSpin:
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+}
+
+// WithTracing marks the time Get, Poll and Put spend blocked on an empty or
+// full queue as spsc.get-blocked-empty and spsc.put-blocked-full regions, so
+// that it shows up in go tool trace instead of looking like busy CPU.
+func WithTracing() Option {
+	return func(rb *RingBuffer) {
+		rb.tracing = true
+	}
+}
+
+// WithDedupAdjacent makes the enqueue methods drop an item that equal reports
+// to be the same as the item enqueued right before it, so a producer
+// repeating itself, e.g. with heartbeats, doesn't fill the queue.  Only the
+// previous item is compared.  PutDeduped reports whether an item was dropped.
+func WithDedupAdjacent(equal func(a, b interface{}) bool) Option {
+	return func(rb *RingBuffer) {
+		rb.equal = equal
+	}
+}
+
+// WithDisposeError makes every method that fails because the queue is
+// disposed return err, e.g. io.EOF for a consumer bridging to an io.Reader,
+// instead of the default error.
+func WithDisposeError(err error) Option {
+	return func(rb *RingBuffer) {
+		rb.disposeErr = err
+	}
+}
+
+// disposedErr returns the error for calls on a disposed queue.
+func (rb *RingBuffer) disposedErr() error {
+	if rb.disposeErr != nil {
+		return rb.disposeErr
+	}
+	return ErrDisposed
+}
+
+// WithTTL stamps every item with the time it was enqueued, and makes Get,
+// Poll and the batch methods skip items that have been in the queue for
+// longer than ttl, which Expired counts.  This saves the consumer from
+// working on stale items after a stall.  It costs a clock read on both sides
+// of every item.
+func WithTTL(ttl time.Duration) Option {
+	return func(rb *RingBuffer) {
+		rb.ttl = ttl
+		rb.stamp()
+	}
+}
+
+// stamp makes the producer record the enqueue time of every item.
+func (rb *RingBuffer) stamp() {
+	if rb.stamps == nil {
+		rb.stamps = make([]int64, len(rb.nodes))
+	}
+}
+
+// stale reports whether item rd has outlived the ttl, counting it if so.
+func (rb *RingBuffer) stale(rd uint64) bool {
+	if rb.ttl <= 0 || time.Now().UnixNano()-rb.stamps[rd&rb.mask] <= int64(rb.ttl) {
+		return false
+	}
+	atomic.AddUint64(&rb.expired, 1)
+	return true
+}
+
+// Expired returns the number of items skipped for outliving the ttl set
+// with WithTTL.
+func (rb *RingBuffer) Expired() uint64 {
+	return atomic.LoadUint64(&rb.expired)
+}
+
+// WithOnDispose calls onDispose exactly once, on the goroutine whose call to
+// Dispose or DisposeWithError disposed of the queue, to release resources
+// tied to it.
+func WithOnDispose(onDispose func()) Option {
+	return func(rb *RingBuffer) {
+		rb.onDispose = onDispose
+	}
 }
 
 // NewRingBuffer will allocate, initialize, and return a ring buffer
 // with the specified size.
-func NewRingBuffer(size uint64) *RingBuffer {
+func NewRingBuffer(size uint64, opts ...Option) *RingBuffer {
 	rb := &RingBuffer{}
 	rb.init(size)
+	for _, opt := range opts {
+		opt(rb)
+	}
 	return rb
 }
 
@@ -61,7 +382,88 @@ func NewRingBuffer(size uint64) *RingBuffer {
 // in the Put and/or Get methods.  Calling those methods on a disposed
 // queue will return an error.
 func (rb *RingBuffer) Dispose() {
-	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+	rb.DisposeWithError(nil)
+}
+
+// DisposeWithError disposes of this queue like Dispose and records err as
+// the cause, which Cause reports.  Only the first call to Dispose or
+// DisposeWithError has any effect.
+func (rb *RingBuffer) DisposeWithError(err error) {
+	rb.disposeOnce.Do(func() {
+		rb.cause.Store(cause{err})
+		atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+		if rb.onDispose != nil {
+			rb.onDispose()
+		}
+	})
+}
+
+// Reset empties the queue and makes it usable again, disposed or not,
+// reusing its nodes instead of allocating a new queue, e.g. to pool queues
+// between connections.  Items still in it are dropped, with the references
+// to them, and the counters start over, while the options the queue was
+// created with are kept.  Reset must not be called concurrently with any
+// other method: both the producer and the consumer must be done with the
+// queue.
+func (rb *RingBuffer) Reset() {
+	if rb.scrub != nil {
+		rb.release()
+	}
+	for i := range rb.nodes {
+		rb.nodes[i] = node{position: uint64(i)}
+	}
+	for i := range rb.batch {
+		rb.batch[i] = nil
+	}
+	rb.batch = rb.batch[:0]
+	rb.last, rb.hasLast = nil, false
+	if rb.latency != nil {
+		*rb.latency = latency{}
+	}
+	for _, counter := range []*uint64{&rb.write, &rb.read, &rb.acked, &rb.dropped, &rb.expired} {
+		atomic.StoreUint64(counter, 0)
+	}
+	rb.disposeOnce = sync.Once{}
+	rb.cause.Store(cause{})
+	atomic.StoreUint64(&rb.disposed, 0)
+}
+
+// DisposeDrain disposes of this queue like Dispose, then takes the items
+// still in it and returns them in order, nil if there is none, clearing
+// their slots, e.g. to hand in-flight work to a fallback during a graceful
+// shutdown.  Cancelled items and items that fail to decode are dropped.  It
+// takes the place of the single consumer.  A Put racing with the dispose may
+// enqueue its item after the drain, so stop the producer first to be sure
+// nothing is left behind.
+func (rb *RingBuffer) DisposeDrain() []interface{} {
+	rb.Dispose()
+	var items []interface{}
+	for {
+		data, ok := rb.takeLeftover()
+		if !ok {
+			return items
+		}
+		items = append(items, data)
+	}
+}
+
+// DisposeIfEmpty disposes of this queue like Dispose if it is empty, and
+// returns whether it did.  Only the single producer may call DisposeIfEmpty,
+// so no Put can slip in between the check and the dispose: the queue can
+// only become emptier meanwhile.
+func (rb *RingBuffer) DisposeIfEmpty() bool {
+	if atomic.LoadUint64(&rb.read) != atomic.LoadUint64(&rb.write) {
+		return false
+	}
+	rb.Dispose()
+	return true
+}
+
+// Cause returns the error the queue was disposed with, or nil if it was
+// disposed with Dispose or has not been disposed.
+func (rb *RingBuffer) Cause() error {
+	c, _ := rb.cause.Load().(cause)
+	return c.err
 }
 
 // IsDisposed will return a bool indicating if this queue has been
@@ -75,6 +477,34 @@ func (rb *RingBuffer) Cap() uint64 {
 	return uint64(len(rb.nodes))
 }
 
+// RequestedCap returns the size the ring buffer was created with, which Cap
+// rounds up to a power of 2.
+func (rb *RingBuffer) RequestedCap() uint64 {
+	return rb.requested
+}
+
+// Len returns the number of items in the queue, counting items cancelled or
+// expired but not skipped yet.  It is a racy snapshot while the producer and
+// consumer are active, but exact when called by either of them about the
+// other's progress up to the call.
+func (rb *RingBuffer) Len() uint64 {
+	// read first: write only grows, so the length can't come out negative.
+	rd := atomic.LoadUint64(&rb.read)
+	return atomic.LoadUint64(&rb.write) - rd
+}
+
+// IsEmpty returns true if the queue holds no items, e.g. for the consumer to
+// decide whether to park rather than poll.
+func (rb *RingBuffer) IsEmpty() bool {
+	return rb.Len() == 0
+}
+
+// IsFull returns true if the queue has no room for another item, e.g. for
+// the producer to decide whether to park rather than attempt an Offer.
+func (rb *RingBuffer) IsFull() bool {
+	return rb.Len() == rb.Cap()
+}
+
 // Get will return the next item in the queue.  This call will block
 // if the queue is empty.  This call will unblock when an item is added
 // to the queue or Dispose is called on the queue.  An error will be returned
@@ -89,38 +519,579 @@ func (rb *RingBuffer) Get() (interface{}, error) {
 // error will be returned if the queue is disposed or a timeout occurs. A
 // non-positive timeout will block indefinitely.
 func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
-	var start time.Time
-	if timeout > 0 {
-		start = time.Now()
+	data, _, err := rb.poll(nil, deadlineAfter(timeout))
+	return data, err
+}
+
+// PollDeadline is Poll with an absolute deadline instead of a timeout, for
+// callers propagating one.  A zero deadline will block indefinitely.
+func (rb *RingBuffer) PollDeadline(deadline time.Time) (interface{}, error) {
+	data, _, err := rb.poll(nil, deadline)
+	return data, err
+}
+
+// deadlineAfter returns the deadline timeout from now, or the zero time for
+// a non-positive timeout.
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// GetIndexed behaves like Get but also returns the absolute consume
+// sequence of the item, i.e. the read cursor before it was advanced. With a
+// single consumer the sequence is gap-free and monotonically increasing.
+func (rb *RingBuffer) GetIndexed() (interface{}, uint64, error) {
+	return rb.poll(nil, time.Time{})
+}
+
+// GetWithRemaining behaves like Get but also returns the number of items left
+// in the queue after this one was taken.  More items may be enqueued
+// concurrently, so the count is a lower bound as soon as it is returned.
+func (rb *RingBuffer) GetWithRemaining() (interface{}, uint64, error) {
+	data, rd, err := rb.poll(nil, time.Time{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, atomic.LoadUint64(&rb.write) - (rd + 1), nil
+}
+
+// poll is PollDeadline returning the consume sequence too.  A non-nil ctx
+// also unblocks the call when it is done, returning ctx.Err().
+func (rb *RingBuffer) poll(ctx context.Context, deadline time.Time) (interface{}, uint64, error) {
+	var spins uint64
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	if rb.scrub != nil {
+		rb.release()
+	}
+	if rb.tracing && atomic.LoadUint64(&rb.read) == atomic.LoadUint64(&rb.write) {
+		defer trace.StartRegion(context.Background(), "spsc.get-blocked-empty").End()
 	}
 
 	rd := atomic.LoadUint64(&rb.read)
 	for {
 		if atomic.LoadUint64(&rb.disposed) > 0 {
-			return nil, errors.New(`queue: closed`)
+			return nil, 0, rb.disposedErr()
 		}
 		wr := atomic.LoadUint64(&rb.write)
 		// Not emtpy.
 		if rd != wr {
-			break
+			if !rb.claim(rd) {
+				// Dropped by the producer.
+				rd = atomic.LoadUint64(&rb.read)
+				continue
+			}
+			n := &rb.nodes[rd&rb.mask]
+			if atomic.LoadUint64(&n.cancelled) != rd+1 && !rb.stale(rd) {
+				break
+			}
+			// Cancelled or expired, skip it.
+			n.data = nil
+			rb.free(rd)
+			rd++
+			continue
 		}
-		if timeout > 0 && time.Since(start) >= timeout {
-			return nil, errors.New(`queue: poll timed out`)
+		spins++
+		if !deadline.IsZero() && spins%rb.timeoutCheckEvery == 0 && !time.Now().Before(deadline) {
+			return nil, 0, ErrTimeout
 		}
-		runtime.Gosched() // free up the cpu before the next iteration
+		if done != nil {
+			select {
+			case <-done:
+				return nil, 0, ctx.Err()
+			default:
+			}
+		}
+		rb.spin()
 	}
 	n := &rb.nodes[rd&rb.mask]
 	data := n.data
 	n.data = nil
-	atomic.StoreUint64(&rb.read, rd+1) // cache coherence traffic.
-	return data, nil
+	if rb.latency != nil {
+		rb.latency.record(rb.stamps[rd&rb.mask], time.Now().UnixNano())
+	}
+	rb.free(rd)
+	if rb.scrub != nil {
+		rb.consumed = append(rb.consumed, data)
+	}
+	if rb.onEmpty != nil && rd+1 == atomic.LoadUint64(&rb.write) {
+		rb.onEmpty()
+	}
+	data, err := rb.decoded(data)
+	return data, rd, err
+}
+
+// TryGet returns the next item in the queue if there is one, without
+// blocking or yielding the processor, e.g. to poll several queues in turn.
+// If the queue is empty, this call will return false.  An error will be
+// returned if the queue is disposed, or if the item fails to decode, see
+// WithCodec.
+func (rb *RingBuffer) TryGet() (interface{}, bool, error) {
+	if atomic.LoadUint64(&rb.disposed) > 0 {
+		return nil, false, rb.disposedErr()
+	}
+	if rb.scrub != nil {
+		rb.release()
+	}
+	var buf [1]interface{}
+	items, err := rb.takeReady(buf[:0], 1, nil)
+	if err != nil || len(items) == 0 {
+		return nil, false, err
+	}
+	return items[0], true, nil
+}
+
+// PollBatchInternal waits for an item like Poll, then takes every other item
+// that is ready too, up to max items in total, publishing the read cursor
+// once.  The items are returned in a slice owned by the queue, which is only
+// valid until the next call to PollBatchInternal: copy anything that must
+// outlive it.
+func (rb *RingBuffer) PollBatchInternal(max int, timeout time.Duration) ([]interface{}, error) {
+	first, _, err := rb.poll(nil, deadlineAfter(timeout))
+	if err != nil {
+		return nil, err
+	}
+	for i := range rb.batch {
+		rb.batch[i] = nil
+	}
+	rb.batch, err = rb.takeReady(append(rb.batch[:0], first), max, nil)
+	return rb.batch, err
+}
+
+// GetAvailable waits up to timeout for an item like Poll, then fills the
+// rest of buf with the items that are ready too, without waiting for more,
+// and returns the number of items placed in buf.  This gets the first item
+// with the latency of Poll and the others with the throughput of a batch.
+// An error will be returned if the queue is disposed or a timeout occurs.
+func (rb *RingBuffer) GetAvailable(buf []interface{}, timeout time.Duration) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	first, _, err := rb.poll(nil, deadlineAfter(timeout))
+	if err != nil {
+		return 0, err
+	}
+	buf[0] = first
+	items, err := rb.takeReady(buf[:1], len(buf), nil)
+	return len(items), err
+}
+
+// GetBatch fills buf with up to len(buf) items that are ready, publishing
+// the read cursor once for the whole batch, and returns the number of items
+// placed in buf.  This call will block like Get while the queue is empty,
+// and returns as soon as there is at least one item, without waiting to fill
+// buf.  An error will be returned if the queue is disposed, along with the
+// number of items taken if it was disposed mid-drain, or if an item fails
+// to decode, see WithCodec.
+func (rb *RingBuffer) GetBatch(buf []interface{}) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if rb.scrub != nil {
+		rb.release()
+	}
+	if rb.tracing && atomic.LoadUint64(&rb.read) == atomic.LoadUint64(&rb.write) {
+		defer trace.StartRegion(context.Background(), "spsc.get-blocked-empty").End()
+	}
+	for {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return 0, rb.disposedErr()
+		}
+		items, err := rb.takeReady(buf[:0], len(buf), nil)
+		if len(items) > 0 && err == nil && atomic.LoadUint64(&rb.disposed) > 0 {
+			err = rb.disposedErr()
+		}
+		if len(items) > 0 || err != nil {
+			return len(items), err
+		}
+		rb.spin()
+	}
+}
+
+// Drain takes every item that is ready, without waiting, and returns them
+// in a new slice, nil if there is none.  Items left in a disposed queue can
+// still be drained.
+func (rb *RingBuffer) Drain() []interface{} {
+	return rb.DrainAppend(nil)
+}
+
+// DrainAppend is Drain appending the items to dst and returning the extended
+// slice, so that a consumer draining often can reuse one buffer and not
+// allocate, e.g. with buf = q.DrainAppend(buf[:0]).  Items that fail to
+// decode, see WithCodec, are dropped.
+func (rb *RingBuffer) DrainAppend(dst []interface{}) []interface{} {
+	if rb.scrub != nil {
+		rb.release()
+	}
+	for {
+		var err error
+		dst, err = rb.takeReady(dst, math.MaxInt, nil)
+		if err == nil {
+			return dst
+		}
+	}
+}
+
+// GetBatchAppend waits for an item like Get, then takes the items that are
+// ready too, up to max items in total, and appends them to dst, returning
+// the extended slice.  Reusing dst across calls, e.g. with
+// buf, err = q.GetBatchAppend(buf[:0], n), saves allocating a batch per
+// call.  An error will be returned if the queue is disposed, along with dst
+// as passed in, or if an item fails to decode, see WithCodec.
+func (rb *RingBuffer) GetBatchAppend(dst []interface{}, max int) ([]interface{}, error) {
+	first, _, err := rb.poll(nil, time.Time{})
+	if err != nil {
+		return dst, err
+	}
+	return rb.takeReady(append(dst, first), len(dst)+max, nil)
+}
+
+// takeReady appends the items that are ready to items, until it holds max
+// items or until an item accept, if non-nil, rejects, and publishes the read
+// cursor once.  The rejected item stays at the head of the queue, which only
+// holds without DropOldest: that policy lets the producer take the head.  It
+// stops at an item that fails to decode too, taking it, and returns the
+// decode error.
+func (rb *RingBuffer) takeReady(items []interface{}, max int, accept func(interface{}) bool) ([]interface{}, error) {
+	start := len(items)
+	var (
+		now int64
+		err error
+	)
+	if rb.latency != nil {
+		now = time.Now().UnixNano()
+	}
+	rd := atomic.LoadUint64(&rb.read)
+	wr := atomic.LoadUint64(&rb.write)
+	for rd != wr && len(items) < max {
+		if !rb.claim(rd) {
+			// Dropped by the producer.
+			rd = atomic.LoadUint64(&rb.read)
+			continue
+		}
+		n := &rb.nodes[rd&rb.mask]
+		if atomic.LoadUint64(&n.cancelled) != rd+1 && !rb.stale(rd) {
+			var data interface{}
+			data, err = rb.decoded(n.data)
+			if err == nil && accept != nil && !accept(data) {
+				break
+			}
+			if err == nil {
+				items = append(items, data)
+			}
+			if rb.latency != nil {
+				rb.latency.record(rb.stamps[rd&rb.mask], now)
+			}
+		}
+		n.data = nil
+		if rb.policy == DropOldest {
+			rb.free(rd)
+		}
+		rd++
+		if err != nil {
+			break
+		}
+	}
+	if rb.policy != DropOldest {
+		atomic.StoreUint64(&rb.read, rd) // cache coherence traffic.
+	}
+	if rb.scrub != nil {
+		rb.consumed = append(rb.consumed, items[start:]...)
+	}
+	if rb.onEmpty != nil && len(items) > start && rd == atomic.LoadUint64(&rb.write) {
+		rb.onEmpty()
+	}
+	return items, err
+}
+
+// GetGrouped waits for an item like Get, then takes the items right behind
+// it for as long as keyFn maps them to the same key as the first, up to
+// maxPerGroup items in total.  It returns the key and the group, in a new
+// slice.  Keys are compared with ==, so keyFn must return comparable values.
+// The producer is expected to enqueue items clustered by key: an item whose
+// key was seen before, but not last, starts a new group.  Since the group
+// ends at the first item with another key, which must stay in the queue,
+// GetGrouped doesn't work with the DropOldest policy and returns
+// ErrDropOldest.
+func (rb *RingBuffer) GetGrouped(keyFn func(interface{}) interface{}, maxPerGroup int) (interface{}, []interface{}, error) {
+	if rb.policy == DropOldest {
+		return nil, nil, ErrDropOldest
+	}
+	first, _, err := rb.poll(nil, time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	key := keyFn(first)
+	items, err := rb.takeReady([]interface{}{first}, maxPerGroup, func(item interface{}) bool {
+		return keyFn(item) == key
+	})
+	return key, items, err
+}
+
+// DrainToChan sends the queue's items to out in batches of up to batchMax,
+// each a new slice, so a channel based consumer pays for one channel send per
+// batch rather than per item.  A batch is sent as soon as no more items are
+// ready, so it can be partial.  Items that fail to decode, see WithCodec,
+// are dropped.  It runs until the queue is disposed, then sends the items
+// left in the queue and closes out.  A batchMax below 1 is taken as 1.
+// DrainToChan takes the place of the single consumer.
+func (rb *RingBuffer) DrainToChan(out chan<- []interface{}, batchMax int) {
+	defer close(out)
+	if batchMax < 1 {
+		batchMax = 1
+	}
+	for {
+		items, err := rb.PollBatchInternal(batchMax, 0)
+		if len(items) > 0 {
+			batch := make([]interface{}, len(items))
+			copy(batch, items)
+			out <- batch
+		}
+		if err != nil && atomic.LoadUint64(&rb.disposed) > 0 {
+			break
+		}
+	}
+	batch := make([]interface{}, 0, batchMax)
+	for {
+		item, ok := rb.takeLeftover()
+		if ok {
+			batch = append(batch, item)
+		}
+		if len(batch) > 0 && (!ok || len(batch) == batchMax) {
+			out <- batch
+			batch = make([]interface{}, 0, batchMax)
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// TruncateToLatest discards all but the n most recently enqueued items, to
+// catch up with the producer, and returns the number of items discarded.
+// The discarded items are dropped for good.  Only the single consumer may
+// call TruncateToLatest.
+func (rb *RingBuffer) TruncateToLatest(n uint64) uint64 {
+	rd := atomic.LoadUint64(&rb.read)
+	wr := atomic.LoadUint64(&rb.write)
+	if wr-rd <= n {
+		return 0
+	}
+	to := wr - n
+	if rb.policy == DropOldest {
+		var discarded uint64
+		for ; int64(to-rd) > 0; rd = atomic.LoadUint64(&rb.read) {
+			if rb.claim(rd) {
+				rb.nodes[rd&rb.mask].data = nil
+				rb.free(rd)
+				discarded++
+			}
+		}
+		return discarded
+	}
+	for i := rd; i != to; i++ {
+		rb.nodes[i&rb.mask].data = nil
+	}
+	atomic.StoreUint64(&rb.read, to) // cache coherence traffic.
+	return to - rd
+}
+
+// Peek returns the next item without consuming it, or false if the queue is
+// empty.  Only the single consumer may call Peek, and the returned item is
+// only valid until its next Get or Peek.  Cancelled items ahead of it, and
+// expired ones with WithTTL, are skipped for good as a Get would.  Peek
+// doesn't work with the DropOldest policy, which lets the producer drop the
+// head under it, and always returns false.  With a codec, Peek also returns
+// false if the item fails to decode.
+func (rb *RingBuffer) Peek() (interface{}, bool) {
+	if rb.policy == DropOldest {
+		return nil, false
+	}
+	rd := atomic.LoadUint64(&rb.read)
+	for rd != atomic.LoadUint64(&rb.write) {
+		n := &rb.nodes[rd&rb.mask]
+		if atomic.LoadUint64(&n.cancelled) != rd+1 && !rb.stale(rd) {
+			data, err := rb.decoded(n.data)
+			return data, err == nil
+		}
+		// Cancelled or expired, skip it.
+		n.data = nil
+		rb.free(rd)
+		rd++
+	}
+	return nil, false
+}
+
+// PeekTail returns the most recently enqueued item without consuming it, or
+// false if the queue is empty.  Only the single consumer may call PeekTail.
+// The producer may enqueue more items while it runs, so the returned item
+// is the latest as of the start of the call, not necessarily its end.  With
+// a codec, PeekTail also returns false if the item fails to decode.
+func (rb *RingBuffer) PeekTail() (interface{}, bool) {
+	rd := atomic.LoadUint64(&rb.read)
+	wr := atomic.LoadUint64(&rb.write)
+	if rd == wr {
+		return nil, false
+	}
+	data, err := rb.decoded(rb.nodes[(wr-1)&rb.mask].data)
+	return data, err == nil
+}
+
+// RunConsumer gets items from the queue and calls handler with each of them
+// until handler fails, an item fails to decode, see WithCodec, ctx is done,
+// or the queue is disposed.  It returns the handler's error, the decode
+// error, ctx.Err(), or nil on dispose respectively, which makes it a natural
+// fit for an errgroup.Group goroutine.  RunConsumer is the single consumer
+// while it runs.
+func (rb *RingBuffer) RunConsumer(ctx context.Context, handler func(context.Context, interface{}) error) error {
+	for {
+		data, _, err := rb.poll(ctx, time.Time{})
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if errors.Is(err, rb.disposedErr()) {
+				return nil
+			}
+			return err
+		}
+		if err := handler(ctx, data); err != nil {
+			return err
+		}
+	}
+}
+
+// RunConsumerSafe gets items from the queue and calls handler with each of
+// them, recovering from any panic in handler: onPanic is then called with
+// the item and the recovered value, and the next item is handled as usual.
+// Items that fail to decode, see WithCodec, are skipped.  Once the queue is
+// disposed, RunConsumerSafe handles the items left in it and returns.
+// RunConsumerSafe is the single consumer while it runs.
+func (rb *RingBuffer) RunConsumerSafe(handler func(interface{}), onPanic func(item interface{}, r interface{})) {
+	handle := func(item interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				onPanic(item, r)
+			}
+		}()
+		handler(item)
+	}
+	for {
+		data, _, err := rb.poll(nil, time.Time{})
+		if err != nil {
+			if errors.Is(err, rb.disposedErr()) {
+				break
+			}
+			// The item failed to decode and is consumed: skip it.
+			continue
+		}
+		handle(data)
+	}
+	for {
+		data, ok := rb.takeLeftover()
+		if !ok {
+			return
+		}
+		handle(data)
+	}
+}
+
+// RunConsumerRetry gets items from the queue and calls handler with each of
+// them.  An item handler fails on is retried after the items that were in
+// the queue at the time, up to maxAttempts calls in total, at least 1, after
+// which deadLetter, if non-nil, is called with the item and the last error.
+// Retries are kept by the consumer rather than put back in the queue, which
+// would make it a second producer and could block it on a full queue.  Items
+// that fail to decode, see WithCodec, are skipped.  Once the queue is
+// disposed, RunConsumerRetry handles the items left in it and their
+// retries, and returns.  RunConsumerRetry is the single consumer while it
+// runs.
+func (rb *RingBuffer) RunConsumerRetry(handler func(interface{}) error, maxAttempts int, deadLetter func(item interface{}, err error)) {
+	type retry struct {
+		item     interface{}
+		attempts int
+		due      uint64 // Retried once read gets there.
+	}
+	var retries []retry
+	handle := func(item interface{}, attempts int) {
+		err := handler(item)
+		if err == nil {
+			return
+		}
+		if attempts++; attempts < maxAttempts {
+			retries = append(retries, retry{item, attempts, atomic.LoadUint64(&rb.write)})
+		} else if deadLetter != nil {
+			deadLetter(item, err)
+		}
+	}
+	for {
+		// A retry that is not due yet has items ahead of it in the queue,
+		// so the get below doesn't wait for the producer.
+		if len(retries) > 0 && int64(atomic.LoadUint64(&rb.read)-retries[0].due) >= 0 {
+			r := retries[0]
+			retries = retries[1:]
+			handle(r.item, r.attempts)
+			continue
+		}
+		data, _, err := rb.poll(nil, time.Time{})
+		if err != nil {
+			if errors.Is(err, rb.disposedErr()) {
+				break
+			}
+			// The item failed to decode and is consumed: skip it.
+			continue
+		}
+		handle(data, 0)
+	}
+	for {
+		data, ok := rb.takeLeftover()
+		if !ok {
+			break
+		}
+		handle(data, 0)
+	}
+	for len(retries) > 0 {
+		r := retries[0]
+		retries = retries[1:]
+		handle(r.item, r.attempts)
+	}
+}
+
+// takeLeftover takes the next item left in a disposed queue, which Get no
+// longer returns, and returns false once there is none.  Items that fail to
+// decode are skipped, as there is no one to return the error to.
+func (rb *RingBuffer) takeLeftover() (interface{}, bool) {
+	for {
+		rd := atomic.LoadUint64(&rb.read)
+		if rd == atomic.LoadUint64(&rb.write) {
+			return nil, false
+		}
+		if !rb.claim(rd) {
+			continue
+		}
+		n := &rb.nodes[rd&rb.mask]
+		data := n.data
+		n.data = nil
+		cancelled := atomic.LoadUint64(&n.cancelled) == rd+1
+		rb.free(rd)
+		if cancelled {
+			continue
+		}
+		if data, err := rb.decoded(data); err == nil {
+			return data, true
+		}
+	}
 }
 
 // Put adds the provided item to the queue.  If the queue is full, this
 // call will block until an item is added to the queue or Dispose is called
 // on the queue.  An error will be returned if the queue is disposed.
 func (rb *RingBuffer) Put(item interface{}) error {
-	_, err := rb.put(item, false)
+	_, err := rb.put(item, false, nil)
 	return err
 }
 
@@ -128,27 +1099,322 @@ func (rb *RingBuffer) Put(item interface{}) error {
 // is full, this call will return false.  An error will be returned if the
 // queue is disposed.
 func (rb *RingBuffer) Offer(item interface{}) (bool, error) {
-	return rb.put(item, true)
+	o, err := rb.put(item, true, nil)
+	return o != refused, err
+}
+
+// PutDeduped adds the provided item to the queue like Put, and returns true
+// if the item was dropped instead because it repeats the previous one, see
+// WithDedupAdjacent.  ErrFull is returned if the DropNewest policy dropped
+// the item.
+func (rb *RingBuffer) PutDeduped(item interface{}) (bool, error) {
+	o, err := rb.put(item, false, nil)
+	if err != nil {
+		return false, err
+	}
+	if o == dropped {
+		return false, ErrFull
+	}
+	return o == deduped, nil
 }
 
-func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
+// PutTracked adds the provided item to the queue like Put and returns its
+// sequence, which the consumer passes to Ack once it has handled the item
+// and the producer can wait on with WaitAck.  ErrFull is returned if the
+// DropNewest policy dropped the item.
+func (rb *RingBuffer) PutTracked(item interface{}) (uint64, error) {
 	wr := atomic.LoadUint64(&rb.write)
+	o, err := rb.put(item, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	switch o {
+	case dropped:
+		return 0, ErrFull
+	case deduped:
+		// The previous item stands for this one.
+		return wr - 1, nil
+	}
+	return wr, nil
+}
+
+// Ack acknowledges that the consumer is done with the item of sequence seq,
+// and, as the queue is FIFO, with every item before it.  It wakes WaitAck
+// calls for those items.
+func (rb *RingBuffer) Ack(seq uint64) {
 	for {
+		a := atomic.LoadUint64(&rb.acked)
+		if int64(a-(seq+1)) >= 0 || atomic.CompareAndSwapUint64(&rb.acked, a, seq+1) {
+			return
+		}
+	}
+}
+
+// WaitAck blocks until the item of sequence seq, as returned by PutTracked,
+// is acknowledged with Ack.  This call will unblock when the item is
+// acknowledged, Dispose is called on the queue, or the timeout is reached.
+// An error will be returned if the queue is disposed or a timeout occurs.  A
+// non-positive timeout will block indefinitely.
+func (rb *RingBuffer) WaitAck(seq uint64, timeout time.Duration) error {
+	ok, err := rb.waitCursor(&rb.acked, seq+1, timeout)
+	if err == nil && !ok {
+		return ErrTimeout
+	}
+	return err
+}
+
+// WaitForWriteSeq blocks until the producer has enqueued at least seq items
+// in total, i.e. until the write cursor reaches seq.  This call will unblock
+// when the cursor gets there, Dispose is called on the queue, or the timeout
+// is reached.  An error will be returned if the queue is disposed or a
+// timeout occurs.  A non-positive timeout will block indefinitely.
+func (rb *RingBuffer) WaitForWriteSeq(seq uint64, timeout time.Duration) error {
+	ok, err := rb.waitCursor(&rb.write, seq, timeout)
+	if err == nil && !ok {
+		return ErrTimeout
+	}
+	return err
+}
+
+// WaitForReadSeq is WaitForWriteSeq for the consumer: it blocks until the
+// consumer has taken at least seq items in total, e.g. for the producer to
+// know the consumer caught up with a checkpoint.
+func (rb *RingBuffer) WaitForReadSeq(seq uint64, timeout time.Duration) error {
+	ok, err := rb.waitCursor(&rb.read, seq, timeout)
+	if err == nil && !ok {
+		return ErrTimeout
+	}
+	return err
+}
+
+// waitCursor waits for cursor to reach seq, and returns false if the timeout
+// is reached first.
+func (rb *RingBuffer) waitCursor(cursor *uint64, seq uint64, timeout time.Duration) (bool, error) {
+	var start time.Time
+	if timeout > 0 {
+		start = time.Now()
+	}
+	for int64(atomic.LoadUint64(cursor)-seq) < 0 {
 		if atomic.LoadUint64(&rb.disposed) > 0 {
-			return false, errors.New(`queue: closed`)
+			return false, rb.disposedErr()
 		}
-		rd := atomic.LoadUint64(&rb.read)
-		// Not full.
-		if wr < rd+rb.Cap() {
+		if timeout > 0 && time.Since(start) >= timeout {
+			return false, nil
+		}
+		rb.spin()
+	}
+	return true, nil
+}
+
+// PutCancelable adds the provided item to the queue like Put and returns a
+// func that cancels it.  A cancelled item is skipped by Get as if it had
+// never been enqueued.  Cancelling an item that was already consumed has no
+// effect.  The cancel func may be called from any goroutine, but like Put,
+// PutCancelable itself must only be called by the single producer.  ErrFull
+// is returned if the DropNewest policy dropped the item.
+func (rb *RingBuffer) PutCancelable(item interface{}) (func(), error) {
+	wr := atomic.LoadUint64(&rb.write)
+	o, err := rb.put(item, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch o {
+	case dropped:
+		return nil, ErrFull
+	case deduped:
+		// There is nothing to cancel.
+		return func() {}, nil
+	}
+	n := &rb.nodes[wr&rb.mask]
+	return func() {
+		for {
+			c := atomic.LoadUint64(&n.cancelled)
+			// Don't undo the cancellation of a later item in this slot.
+			if int64(c-(wr+1)) >= 0 || atomic.CompareAndSwapUint64(&n.cancelled, c, wr+1) {
+				return
+			}
+		}
+	}, nil
+}
+
+// PutBatch adds as many of the provided items as fit in the free space to
+// the queue, in order, publishing the write cursor once for the whole batch,
+// and returns the number of items enqueued.  It never blocks: if the queue
+// fills up mid-batch, the count is partial and items[n:] can be retried
+// later.  Items dropped by WithDedupAdjacent count as enqueued.  An error
+// will be returned if the queue is disposed, or if an item fails validation
+// or encoding, along with the number of items enqueued before it.
+func (rb *RingBuffer) PutBatch(items []interface{}) (int, error) {
+	if atomic.LoadUint64(&rb.disposed) > 0 {
+		return 0, rb.disposedErr()
+	}
+	wr := atomic.LoadUint64(&rb.write)
+	free := rb.Cap() - (wr - atomic.LoadUint64(&rb.read))
+	var (
+		i   int
+		err error
+	)
+	for ; i < len(items); i++ {
+		item := items[i]
+		if rb.validate != nil {
+			if err = rb.validate(item); err != nil {
+				break
+			}
+		}
+		if rb.equal != nil && rb.hasLast && rb.equal(rb.last, item) {
+			continue
+		}
+		// Full, or the consumer is still reading the slot's previous item.
+		if free == 0 || rb.policy == DropOldest && atomic.LoadUint64(&rb.nodes[wr&rb.mask].position) != wr {
 			break
 		}
-		if offer {
-			return false, nil
+		stored := item
+		if rb.encode != nil {
+			var b []byte
+			if b, err = rb.encode(item); err != nil {
+				break
+			}
+			stored = b
 		}
-		runtime.Gosched() // free up the cpu before the next iteration
+		rb.nodes[wr&rb.mask].data = stored
+		if rb.stamps != nil {
+			rb.stamps[wr&rb.mask] = time.Now().UnixNano()
+		}
+		if rb.equal != nil {
+			rb.last, rb.hasLast = item, true
+		}
+		wr++
+		free--
+	}
+	atomic.StoreUint64(&rb.write, wr) // cache coherence traffic.
+	return i, err
+}
+
+// PutWith adds the provided item to the queue.  If the queue is full, backoff
+// is called with the number of failed attempts so far, starting at 1, in
+// place of the default yield.  Returning true retries the put, returning
+// false gives up and ErrTimeout is returned.  This lets callers plug in
+// any waiting policy.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer) PutWith(item interface{}, backoff func(attempt int) bool) error {
+	o, err := rb.put(item, false, backoff)
+	if err == nil && o == refused {
+		return ErrTimeout
+	}
+	return err
+}
+
+// offerForCheckEvery is how many spins OfferFor does between clock reads.
+const offerForCheckEvery = 16
+
+// OfferFor adds the provided item to the queue, busy-spinning for up to
+// budget while the queue is full.  Unlike Put it never yields the processor,
+// which suits sub-microsecond waits where rescheduling the goroutine would
+// cost more than the wait itself.  The clock is only read every few spins,
+// so the budget may be overrun slightly.  If the queue is still full after
+// budget, this call will return false.  An error will be returned if the
+// queue is disposed.
+func (rb *RingBuffer) OfferFor(item interface{}, budget time.Duration) (bool, error) {
+	start := time.Now()
+	o, err := rb.put(item, false, func(attempt int) bool {
+		return attempt%offerForCheckEvery != 0 || time.Since(start) < budget
+	})
+	return o != refused, err
+}
+
+// Reserve reserves the next slot for the producer without publishing it.
+// It returns a pointer into the slot, so a large payload can be built in
+// place, and a token to pass to Commit once the slot is filled.  It returns
+// false if the queue is full or disposed.  Only the single producer may call
+// Reserve, and the pointer is valid until Commit.  Reserving again before
+// Commit returns the same slot.
+func (rb *RingBuffer) Reserve() (*interface{}, uint64, bool) {
+	if atomic.LoadUint64(&rb.disposed) > 0 {
+		return nil, 0, false
+	}
+	wr := atomic.LoadUint64(&rb.write)
+	rd := atomic.LoadUint64(&rb.read)
+	// Full.
+	if wr-rd >= rb.Cap() {
+		return nil, 0, false
+	}
+	n := &rb.nodes[wr&rb.mask]
+	// The consumer is still reading the slot's previous item.
+	if rb.policy == DropOldest && atomic.LoadUint64(&n.position) != wr {
+		return nil, 0, false
+	}
+	return &n.data, wr, true
+}
+
+// Commit publishes the slot reserved by the Reserve call that returned
+// token, making it visible to the consumer.
+func (rb *RingBuffer) Commit(token uint64) {
+	if rb.stamps != nil {
+		rb.stamps[token&rb.mask] = time.Now().UnixNano()
+	}
+	atomic.StoreUint64(&rb.write, token+1) // cache coherence traffic.
+}
+
+func (rb *RingBuffer) put(item interface{}, offer bool, backoff func(attempt int) bool) (outcome, error) {
+	if rb.validate != nil {
+		if err := rb.validate(item); err != nil {
+			return refused, err
+		}
+	}
+	if rb.tracing && atomic.LoadUint64(&rb.write)-atomic.LoadUint64(&rb.read) >= rb.Cap() {
+		defer trace.StartRegion(context.Background(), "spsc.put-blocked-full").End()
+	}
+	if rb.equal != nil && rb.hasLast && rb.equal(rb.last, item) {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return refused, rb.disposedErr()
+		}
+		return deduped, nil
+	}
+	stored := item
+	if rb.encode != nil {
+		b, err := rb.encode(item)
+		if err != nil {
+			return refused, err
+		}
+		stored = b
+	}
+	var attempt int
+	wr := atomic.LoadUint64(&rb.write)
+	for {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return refused, rb.disposedErr()
+		}
+		rd := atomic.LoadUint64(&rb.read)
+		// Not full.
+		if wr-rd < rb.Cap() {
+			// The consumer may still be reading the slot's previous item.
+			if rb.policy != DropOldest || atomic.LoadUint64(&rb.nodes[wr&rb.mask].position) == wr {
+				break
+			}
+		} else if offer {
+			return refused, nil
+		} else if backoff != nil {
+			attempt++
+			if !backoff(attempt) {
+				return refused, nil
+			}
+			continue
+		} else if rb.policy != Block {
+			retry, o, err := rb.full(wr, rd)
+			if !retry {
+				return o, err
+			}
+			continue
+		}
+		rb.spin()
 	}
 	n := &rb.nodes[wr&rb.mask]
-	n.data = item
+	n.data = stored
+	if rb.stamps != nil {
+		rb.stamps[wr&rb.mask] = time.Now().UnixNano()
+	}
+	if rb.equal != nil {
+		rb.last, rb.hasLast = item, true
+	}
 	atomic.StoreUint64(&rb.write, wr+1) // cache coherence traffic.
-	return true, nil
+	return written, nil
 }
@@ -1,7 +1,24 @@
 package spsc
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lockfree/internal/queuetest"
+	"log"
+	"math"
+	"runtime"
+	"runtime/trace"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func BenchmarkChannel(b *testing.B) {
@@ -33,3 +50,2012 @@ func BenchmarkSPSC(b *testing.B) {
 		q.Put(`a`)
 	}
 }
+
+func TestGetIndexed(t *testing.T) {
+	const numItems = 1_000
+	q := NewRingBuffer(16)
+
+	go func() {
+		for i := 0; i < numItems; i++ {
+			q.Put(i)
+		}
+	}()
+
+	for i := 0; i < numItems; i++ {
+		got, seq, err := q.GetIndexed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != uint64(i) {
+			t.Fatalf("expected sequence %d, got %d", i, seq)
+		}
+		if got != i {
+			t.Fatalf("expected item %d, got %v", i, got)
+		}
+	}
+}
+
+func TestPutWithGivesUp(t *testing.T) {
+	q := NewRingBuffer(4)
+	for i := uint64(0); i < q.Cap(); i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls int
+	err := q.PutWith(`a`, func(attempt int) bool {
+		calls++
+		if attempt != calls {
+			t.Fatalf("expected attempt %d, got %d", calls, attempt)
+		}
+		return attempt < 10
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected put to give up on a full queue with %v, got %v", ErrTimeout, err)
+	}
+	if calls != 10 {
+		t.Fatalf("expected 10 backoff calls, got %d", calls)
+	}
+}
+
+func TestNilPayloads(t *testing.T) {
+	items := []interface{}{nil, 1, nil, nil, 2, nil, 3}
+	q := NewRingBuffer(4)
+
+	go func() {
+		for _, item := range items {
+			q.Put(item)
+		}
+	}()
+
+	for i, want := range items {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("item %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestLeakDetection(t *testing.T) {
+	leaked := make(chan string, 2)
+	leakLogf = func(format string, v ...interface{}) {
+		leaked <- fmt.Sprintf(format, v...)
+	}
+	defer func() { leakLogf = log.Printf }()
+
+	func() {
+		disposed := NewRingBuffer(4, WithLeakDetection())
+		disposed.Dispose()
+		NewRingBuffer(4, WithLeakDetection())
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case msg := <-leaked:
+			if !strings.Contains(msg, "TestLeakDetection") {
+				t.Fatalf("expected creation stack in warning, got %q", msg)
+			}
+			select {
+			case msg := <-leaked:
+				t.Fatalf("expected a single warning, got another: %q", msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+			return
+		case <-deadline:
+			t.Fatal("expected a leak warning for the undisposed queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestReserveCommit(t *testing.T) {
+	q := NewRingBuffer(2)
+	for i := 0; i < 2; i++ {
+		ptr, token, ok := q.Reserve()
+		if !ok {
+			t.Fatal("expected a slot to be reserved")
+		}
+		*ptr = []int{i, i}
+		q.Commit(token)
+	}
+	if _, _, ok := q.Reserve(); ok {
+		t.Fatal("expected reserve on a full queue to fail")
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := got.([]int); s[0] != i || s[1] != i {
+			t.Fatalf("expected [%d %d], got %v", i, i, s)
+		}
+	}
+}
+
+func TestRunConsumerHandlerError(t *testing.T) {
+	errStop := errors.New("stop")
+	q := NewRingBuffer(16)
+	for i := 0; i < 10; i++ {
+		q.Put(i)
+	}
+
+	var handled []interface{}
+	err := q.RunConsumer(context.Background(), func(ctx context.Context, item interface{}) error {
+		handled = append(handled, item)
+		if item == 4 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if len(handled) != 5 {
+		t.Fatalf("expected consumption to stop after 5 items, got %v", handled)
+	}
+	if got, _ := q.Get(); got != 5 {
+		t.Fatalf("expected the next item to be 5, got %v", got)
+	}
+}
+
+func TestRunConsumerStops(t *testing.T) {
+	q := NewRingBuffer(16)
+	handler := func(context.Context, interface{}) error { return nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() { errs <- q.RunConsumer(ctx, handler) }()
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	go func() { errs <- q.RunConsumer(context.Background(), handler) }()
+	q.Dispose()
+	if err := <-errs; err != nil {
+		t.Fatalf("expected nil on dispose, got %v", err)
+	}
+}
+
+// TestRunConsumerDecodeError checks that an item failing to decode is not
+// mistaken for a dispose: RunConsumer returns the error, while
+// RunConsumerSafe and RunConsumerRetry skip the item and carry on.
+func TestRunConsumerDecodeError(t *testing.T) {
+	errBad := errors.New("bad item")
+	newQueue := func() *RingBuffer {
+		encode := func(item interface{}) ([]byte, error) { return []byte(item.(string)), nil }
+		decode := func(b []byte) (interface{}, error) {
+			if string(b) == "bad" {
+				return nil, errBad
+			}
+			return string(b), nil
+		}
+		q := NewRingBuffer(16, WithCodec(encode, decode))
+		for _, item := range []string{"bad", "a", "b"} {
+			q.Put(item)
+		}
+		return q
+	}
+
+	q := newQueue()
+	err := q.RunConsumer(context.Background(), func(context.Context, interface{}) error { return nil })
+	if !errors.Is(err, errBad) {
+		t.Fatalf("expected %v, got %v", errBad, err)
+	}
+	if q.IsDisposed() {
+		t.Fatal("expected the queue to be left live")
+	}
+	if got, _ := q.Get(); got != "a" {
+		t.Fatalf("expected the next item to be a, got %v", got)
+	}
+
+	var handled []interface{}
+	record := func(item interface{}) {
+		handled = append(handled, item)
+		if len(handled) == 2 {
+			q.Dispose()
+		}
+	}
+	q = newQueue()
+	q.RunConsumerSafe(record, func(interface{}, interface{}) {})
+	if fmt.Sprint(handled) != "[a b]" {
+		t.Fatalf("expected RunConsumerSafe to skip the bad item, got %v", handled)
+	}
+
+	handled = nil
+	q = newQueue()
+	q.RunConsumerRetry(func(item interface{}) error { record(item); return nil }, 1, nil)
+	if fmt.Sprint(handled) != "[a b]" {
+		t.Fatalf("expected RunConsumerRetry to skip the bad item, got %v", handled)
+	}
+}
+
+func TestCause(t *testing.T) {
+	errFault := errors.New("fault")
+
+	q := NewRingBuffer(4)
+	if err := q.Cause(); err != nil {
+		t.Fatalf("expected nil cause before dispose, got %v", err)
+	}
+	q.DisposeWithError(errFault)
+	q.DisposeWithError(errors.New("later"))
+	if err := q.Cause(); err != errFault {
+		t.Fatalf("expected %v, got %v", errFault, err)
+	}
+	if !q.IsDisposed() {
+		t.Fatal("expected queue to be disposed")
+	}
+
+	q = NewRingBuffer(4)
+	q.Dispose()
+	q.DisposeWithError(errFault)
+	if err := q.Cause(); err != nil {
+		t.Fatalf("expected nil cause after a plain dispose, got %v", err)
+	}
+}
+
+func TestPutCancelable(t *testing.T) {
+	q := NewRingBuffer(4)
+	cancels := make([]func(), 3)
+	for i := range cancels {
+		cancel, err := q.PutCancelable(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancels[i] = cancel
+	}
+	cancels[1]()
+
+	for _, want := range []int{0, 2} {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("expected %d, got %v", want, got)
+		}
+	}
+
+	// Cancelling consumed items doesn't affect items reusing their slots.
+	cancels[0]()
+	q.Put(3)
+	q.Put(4)
+	cancels[2]()
+	for _, want := range []int{3, 4} {
+		if got, _ := q.Get(); got != want {
+			t.Fatalf("expected %d, got %v", want, got)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	q := NewRingBuffer(4, WithValidator(func(item interface{}) error {
+		if item.(int) < 0 {
+			return errInvalid
+		}
+		return nil
+	}))
+
+	if err := q.Put(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Put(-1); err != errInvalid {
+		t.Fatalf("expected %v, got %v", errInvalid, err)
+	}
+	if ok, err := q.Offer(-2); ok || err != errInvalid {
+		t.Fatalf("expected offer to be rejected, got %v, %v", ok, err)
+	}
+	if wr := atomic.LoadUint64(&q.write); wr != 1 {
+		t.Fatalf("expected write to stay at 1, got %d", wr)
+	}
+	if err := q.Put(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{1, 2} {
+		if got, _ := q.Get(); got != want {
+			t.Fatalf("expected %d, got %v", want, got)
+		}
+	}
+}
+
+func TestPeekTail(t *testing.T) {
+	q := NewRingBuffer(4)
+	if _, ok := q.PeekTail(); ok {
+		t.Fatal("expected peek on an empty queue to fail")
+	}
+	for i := 0; i < 6; i++ {
+		q.Put(i)
+		if got, ok := q.PeekTail(); !ok || got != i {
+			t.Fatalf("expected tail %d, got %v, %v", i, got, ok)
+		}
+		if i >= 2 {
+			q.Get()
+		}
+	}
+	if got, _ := q.Get(); got != 4 {
+		t.Fatalf("expected peeking not to consume, got %v", got)
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	q := NewRingBuffer(4)
+	if item, ok, err := q.TryGet(); ok || err != nil {
+		t.Fatalf("expected nothing from an empty queue, got %v, %v, %v", item, ok, err)
+	}
+	for i := 0; i < 3; i++ {
+		q.Put(i)
+	}
+	for i := 0; i < 3; i++ {
+		if item, ok, err := q.TryGet(); item != i || !ok || err != nil {
+			t.Fatalf("expected %d, got %v, %v, %v", i, item, ok, err)
+		}
+	}
+	if _, ok, _ := q.TryGet(); ok {
+		t.Fatal("expected nothing once drained")
+	}
+	q.Put(3)
+	q.Dispose()
+	if _, _, err := q.TryGet(); err != ErrDisposed {
+		t.Fatalf("expected ErrDisposed, got %v", err)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	q := NewRingBuffer(4)
+	if _, ok := q.Peek(); ok {
+		t.Fatal("expected peek on an empty queue to fail")
+	}
+	cancel, _ := q.PutCancelable(0)
+	q.Put(1)
+	q.Put(2)
+	cancel()
+	for i := 0; i < 2; i++ {
+		if got, ok := q.Peek(); !ok || got != 1 {
+			t.Fatalf("expected head 1 past the cancelled item, got %v, %v", got, ok)
+		}
+	}
+	if got, _ := q.Get(); got != 1 {
+		t.Fatalf("expected peeking not to consume, got %v", got)
+	}
+	if got, ok := q.Peek(); !ok || got != 2 {
+		t.Fatalf("expected head 2, got %v, %v", got, ok)
+	}
+
+	q = NewRingBuffer(4, WithFullPolicy(DropOldest))
+	q.Put(0)
+	if _, ok := q.Peek(); ok {
+		t.Fatal("expected peek to fail with DropOldest")
+	}
+}
+
+func TestOnEmpty(t *testing.T) {
+	var fired int
+	q := NewRingBuffer(8, WithOnEmpty(func() { fired++ }))
+
+	q.Put(1)
+	q.Put(2)
+	q.Get()
+	if fired != 0 {
+		t.Fatalf("expected no callback while items remain, got %d", fired)
+	}
+	q.Get()
+	if fired != 1 {
+		t.Fatalf("expected one callback after emptying, got %d", fired)
+	}
+	if _, err := q.Poll(time.Millisecond); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+	if fired != 1 {
+		t.Fatalf("expected no callback while empty, got %d", fired)
+	}
+
+	q.Put(3)
+	q.Get()
+	if fired != 2 {
+		t.Fatalf("expected a callback per empty transition, got %d", fired)
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	items := []string{"hello", "", "lockfree", "frames"}
+	q := NewRingBuffer(2)
+	r := q.FrameReader(func(item interface{}) ([]byte, error) {
+		return []byte(item.(string)), nil
+	})
+
+	go func() {
+		for _, item := range items {
+			q.Put(item)
+		}
+		q.Dispose()
+	}()
+
+	// A tiny buffer forces frames to be split across reads.
+	var out bytes.Buffer
+	if _, err := io.CopyBuffer(&out, struct{ io.Reader }{r}, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	for _, want := range items {
+		var size uint32
+		if err := binary.Read(&out, binary.BigEndian, &size); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, size)
+		if _, err := io.ReadFull(&out, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+	if out.Len() != 0 {
+		t.Fatalf("unexpected trailing bytes: %q", out.Bytes())
+	}
+}
+
+func TestPollBatchInternal(t *testing.T) {
+	q := NewRingBuffer(8)
+	for i := 0; i < 7; i++ {
+		q.Put(i)
+	}
+
+	batch, err := q.PollBatchInternal(4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 4 {
+		t.Fatalf("expected 4 items, got %v", batch)
+	}
+	for i, item := range batch {
+		if item != i {
+			t.Fatalf("expected %d, got %v", i, item)
+		}
+	}
+
+	batch, err = q.PollBatchInternal(4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 {
+		t.Fatalf("expected the 3 remaining items, got %v", batch)
+	}
+	for i, item := range batch {
+		if item != i+4 {
+			t.Fatalf("expected %d, got %v", i+4, item)
+		}
+	}
+
+	if _, err := q.PollBatchInternal(4, time.Millisecond); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+}
+
+func TestOfferFor(t *testing.T) {
+	q := NewRingBuffer(2)
+	for i := 0; i < 2; i++ {
+		if ok, err := q.OfferFor(i, time.Millisecond); !ok || err != nil {
+			t.Fatalf("expected offer to succeed, got %v, %v", ok, err)
+		}
+	}
+
+	start := time.Now()
+	if ok, err := q.OfferFor(2, time.Millisecond); ok || err != nil {
+		t.Fatalf("expected offer on a full queue to give up, got %v, %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond {
+		t.Fatalf("expected offer to spin for its budget, gave up after %v", elapsed)
+	}
+
+	q.Dispose()
+	if _, err := q.OfferFor(2, time.Millisecond); err == nil {
+		t.Fatal("expected an error after dispose")
+	}
+}
+
+// benchmarkBrieflyFull measures enqueuing into a small queue that is full
+// most of the time, drained by a consumer that keeps up on average.
+func benchmarkBrieflyFull(b *testing.B, put func(q *RingBuffer, item interface{})) {
+	q := NewRingBuffer(4)
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		put(q, `a`)
+	}
+}
+
+func BenchmarkBrieflyFullPut(b *testing.B) {
+	benchmarkBrieflyFull(b, func(q *RingBuffer, item interface{}) {
+		q.Put(item)
+	})
+}
+
+func BenchmarkBrieflyFullOfferFor(b *testing.B) {
+	benchmarkBrieflyFull(b, func(q *RingBuffer, item interface{}) {
+		// Fall back to yielding once the spin budget is spent.
+		if ok, _ := q.OfferFor(item, time.Microsecond); !ok {
+			q.Put(item)
+		}
+	})
+}
+
+func TestSecureClear(t *testing.T) {
+	q := NewRingBuffer(4, WithSecureClear(func(item interface{}) {
+		if b, ok := item.([]byte); ok {
+			for i := range b {
+				b[i] = 0
+			}
+		}
+	}))
+
+	secret := []byte("token")
+	q.Put(secret)
+	q.Put(1)
+
+	got, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.([]byte)) != "token" {
+		t.Fatalf("expected the secret intact until the next Get, got %q", got)
+	}
+	if q.nodes[0].data != nil {
+		t.Fatal("expected the consumed slot to be cleared")
+	}
+
+	q.Get()
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Fatalf("expected the secret to be zeroed, got %q", secret)
+	}
+}
+
+func TestOnDisposeOnce(t *testing.T) {
+	var calls int32
+	q := NewRingBuffer(4, WithOnDispose(func() {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				q.Dispose()
+			} else {
+				q.DisposeWithError(errors.New("fault"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected the callback to run once, ran %d times", calls)
+	}
+}
+
+func TestGetWithRemaining(t *testing.T) {
+	q := NewRingBuffer(8)
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	for i := 0; i < 5; i++ {
+		got, remaining, err := q.GetWithRemaining()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i || remaining != uint64(4-i) {
+			t.Fatalf("expected %d with %d remaining, got %v with %d", i, 4-i, got, remaining)
+		}
+	}
+}
+
+func TestPollTimeoutCheckInterval(t *testing.T) {
+	for _, every := range []uint64{1, defaultTimeoutCheckEvery, 1024} {
+		q := NewRingBuffer(4, WithTimeoutCheckInterval(every))
+		start := time.Now()
+		if _, err := q.Poll(time.Millisecond); err == nil {
+			t.Fatal("expected poll on an empty queue to time out")
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond || elapsed > 100*time.Millisecond {
+			t.Fatalf("checking every %d spins: timed out after %v", every, elapsed)
+		}
+	}
+}
+
+func TestPollDeadline(t *testing.T) {
+	q := NewRingBuffer(4)
+	start := time.Now()
+	if _, err := q.PollDeadline(start.Add(5 * time.Millisecond)); err == nil {
+		t.Fatal("expected poll on an empty queue to time out")
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond || elapsed > 100*time.Millisecond {
+		t.Fatalf("timed out after %v", elapsed)
+	}
+	if _, err := q.PollDeadline(start); err == nil {
+		t.Fatal("expected a past deadline to time out")
+	}
+
+	// A zero deadline blocks until there is an item.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(1)
+	}()
+	if item, err := q.PollDeadline(time.Time{}); item != 1 || err != nil {
+		t.Fatalf("expected 1, got %v, %v", item, err)
+	}
+}
+
+func benchmarkEmptyPoll(b *testing.B, every uint64) {
+	q := NewRingBuffer(4, WithTimeoutCheckInterval(every))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Poll(10 * time.Microsecond)
+	}
+}
+
+func BenchmarkEmptyPollCheckEverySpin(b *testing.B) {
+	benchmarkEmptyPoll(b, 1)
+}
+
+func BenchmarkEmptyPollCheckEvery64Spins(b *testing.B) {
+	benchmarkEmptyPoll(b, defaultTimeoutCheckEvery)
+}
+
+func TestTracingRegions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skip("tracing unavailable:", err)
+	}
+
+	q := NewRingBuffer(2, WithTracing())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(0)
+		q.Put(1)
+		q.Put(2)
+	}()
+	for i := 0; i < 3; i++ {
+		q.Get()
+		time.Sleep(10 * time.Millisecond)
+	}
+	trace.Stop()
+
+	for _, region := range []string{"spsc.get-blocked-empty", "spsc.put-blocked-full"} {
+		if !bytes.Contains(buf.Bytes(), []byte(region)) {
+			t.Errorf("expected a %s region in the trace", region)
+		}
+	}
+}
+
+func TestTruncateToLatest(t *testing.T) {
+	q := NewRingBuffer(128)
+	for i := 0; i < 100; i++ {
+		q.Put(i)
+	}
+
+	if n := q.TruncateToLatest(10); n != 90 {
+		t.Fatalf("expected 90 items discarded, got %d", n)
+	}
+	if n := q.TruncateToLatest(10); n != 0 {
+		t.Fatalf("expected nothing left to discard, got %d", n)
+	}
+	for i := 90; i < 100; i++ {
+		if got, _ := q.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.nodes[0].data != nil {
+		t.Fatal("expected discarded slots to be cleared")
+	}
+}
+
+// TestTruncateToLatestWraparound discards items across the point where the
+// uint64 sequences wrap around to 0, so the discarded range ends below where
+// it starts.
+func TestTruncateToLatestWraparound(t *testing.T) {
+	q := NewRingBuffer(16)
+	q.fastForward(math.MaxUint64 - 4)
+	for i := 0; i < 12; i++ {
+		q.Put(i)
+	}
+
+	if n := q.TruncateToLatest(2); n != 10 {
+		t.Fatalf("expected 10 items discarded, got %d", n)
+	}
+	for i := 10; i < 12; i++ {
+		if got, _ := q.Get(); got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	for i := range q.nodes {
+		if q.nodes[i].data != nil {
+			t.Fatalf("expected slot %d to be cleared, got %v", i, q.nodes[i].data)
+		}
+	}
+}
+
+func TestDedupAdjacent(t *testing.T) {
+	q := NewRingBuffer(16, WithDedupAdjacent(func(a, b interface{}) bool {
+		return a == b
+	}))
+	var dropped int
+	for _, item := range []string{"a", "a", "a", "b", "a", "c", "c"} {
+		deduped, err := q.PutDeduped(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deduped {
+			dropped++
+		}
+	}
+	if dropped != 3 {
+		t.Fatalf("expected 3 items deduped, got %d", dropped)
+	}
+	for _, want := range []string{"a", "b", "a", "c"} {
+		if got, _ := q.Get(); got != want {
+			t.Fatalf("expected %s, got %v", want, got)
+		}
+	}
+	if ok, _ := q.Offer("c"); !ok {
+		t.Fatal("expected a deduped Offer to succeed")
+	}
+	if _, err := q.Poll(time.Millisecond); err == nil {
+		t.Fatal("expected nothing enqueued")
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 7, 8, 15, 16, 17, 1000, 123456789, 1 << 63} {
+		i := latencyBucket(v)
+		lo := latencyValue(i)
+		if lo > v || v-lo > lo/latencySubBuckets {
+			t.Fatalf("value %d in bucket %d starting at %d", v, i, lo)
+		}
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	q := NewRingBuffer(128, WithLatencyHistogram())
+	if p50, _, _ := q.LatencyPercentiles(); p50 != 0 {
+		t.Fatalf("expected no latency before any Get, got %v", p50)
+	}
+
+	// 90 items go straight through, 10 wait for 20ms.
+	for i := 0; i < 90; i++ {
+		q.Put(i)
+		q.Get()
+	}
+	for i := 0; i < 10; i++ {
+		q.Put(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		q.Get()
+	}
+
+	p50, p90, p99 := q.LatencyPercentiles()
+	if p50 >= time.Millisecond {
+		t.Fatalf("expected p50 well under 1ms, got %v", p50)
+	}
+	if p90 > p99 {
+		t.Fatalf("expected p90 <= p99, got %v > %v", p90, p99)
+	}
+	if p99 < 17*time.Millisecond || p99 > time.Second {
+		t.Fatalf("expected p99 around 20ms, got %v", p99)
+	}
+}
+
+func TestReset(t *testing.T) {
+	q := NewRingBuffer(4, WithFullPolicy(DropNewest))
+	for i := 0; i < 6; i++ {
+		q.Put(i)
+	}
+	q.Get()
+	q.DisposeWithError(errors.New("connection lost"))
+	first := &q.nodes[0]
+
+	q.Reset()
+	if q.IsDisposed() || q.Cause() != nil {
+		t.Fatal("expected a reset queue not to be disposed")
+	}
+	if l := q.Len(); l != 0 {
+		t.Fatalf("expected a reset queue to be empty, got length %d", l)
+	}
+	if d := q.Dropped(); d != 0 {
+		t.Fatalf("expected the counters to start over, got %d dropped", d)
+	}
+	for i, n := range q.nodes {
+		if n.data != nil {
+			t.Fatalf("expected slot %d cleared, got %v", i, n.data)
+		}
+	}
+	if &q.nodes[0] != first {
+		t.Fatal("expected the nodes to be reused")
+	}
+
+	for i := 0; i < 6; i++ {
+		q.Put(i)
+	}
+	for i := 0; i < 4; i++ {
+		if got, err := q.Get(); got != i || err != nil {
+			t.Fatalf("expected %d, got %v, %v", i, got, err)
+		}
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	q := NewRingBuffer(1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		q.Put(i)
+		q.Reset()
+	}
+}
+
+func BenchmarkNewRingBuffer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		q := NewRingBuffer(1024)
+		q.Put(i)
+	}
+}
+
+func TestDisposeDrain(t *testing.T) {
+	q := NewRingBuffer(8)
+	if items := q.DisposeDrain(); items != nil || !q.IsDisposed() {
+		t.Fatalf("expected nothing drained from a disposed queue, got %v", items)
+	}
+
+	q = NewRingBuffer(8)
+	for i := 0; i < 10; i++ {
+		q.Offer(i)
+	}
+	q.Get()
+	items := q.DisposeDrain()
+	if fmt.Sprint(items) != "[1 2 3 4 5 6 7]" {
+		t.Fatalf("expected [1 2 3 4 5 6 7], got %v", items)
+	}
+	if !q.IsDisposed() {
+		t.Fatal("expected the queue to be disposed")
+	}
+	for i, n := range q.nodes {
+		if n.data != nil {
+			t.Fatalf("expected slot %d cleared, got %v", i, n.data)
+		}
+	}
+	if _, err := q.Get(); err != ErrDisposed {
+		t.Fatalf("expected ErrDisposed, got %v", err)
+	}
+}
+
+func TestDisposeIfEmpty(t *testing.T) {
+	q := NewRingBuffer(4)
+	q.Put(1)
+	if q.DisposeIfEmpty() {
+		t.Fatal("expected a non-empty queue not to be disposed")
+	}
+	q.Get()
+	if !q.DisposeIfEmpty() || !q.IsDisposed() {
+		t.Fatal("expected an empty queue to be disposed")
+	}
+}
+
+func TestDisposeIfEmptyRace(t *testing.T) {
+	const count = 10000
+	q := NewRingBuffer(4)
+
+	got := make(chan int)
+	go func() {
+		var n int
+		for {
+			if _, err := q.Get(); err != nil {
+				got <- n
+				return
+			}
+			n++
+		}
+	}()
+
+	// The consumer is still draining while the producer checks, so the
+	// dispose can only land once every item was consumed.
+	for i := 0; i < count; i++ {
+		q.Put(i)
+	}
+	for !q.DisposeIfEmpty() {
+		runtime.Gosched()
+	}
+	if n := <-got; n != count {
+		t.Fatalf("expected %d items consumed, got %d", count, n)
+	}
+}
+
+func TestPutTrackedAck(t *testing.T) {
+	q := NewRingBuffer(4)
+
+	go func() {
+		for {
+			item, seq, err := q.GetIndexed()
+			if err != nil {
+				return
+			}
+			if item == "skip" {
+				continue
+			}
+			q.Ack(seq)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		seq, err := q.PutTracked(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.WaitAck(seq, time.Second); err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+	}
+
+	seq, _ := q.PutTracked("skip")
+	if err := q.WaitAck(seq, 10*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected an unacknowledged item to time out, got %v", err)
+	}
+	q.Dispose()
+	if err := q.WaitAck(seq, 0); err == nil {
+		t.Fatal("expected WaitAck on a disposed queue to fail")
+	}
+}
+
+// TestNodeSize documents the size of a node on 64-bit platforms: two uint64s
+// and an interface{}, two nodes to a 64 byte cache line.
+func TestNodeSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("node sizes are documented for 64-bit platforms")
+	}
+	if size := unsafe.Sizeof(node{}); size != 32 {
+		t.Fatalf("expected a 32 byte node, got %d", size)
+	}
+}
+
+func TestDoubleBufferedQueue(t *testing.T) {
+	const n = 4
+	var made []*[64]byte
+	q := NewDoubleBufferedQueue(n, func() interface{} {
+		buf := new([64]byte)
+		made = append(made, buf)
+		return buf
+	})
+
+	done := make(chan map[*[64]byte]int)
+	go func() {
+		seen := make(map[*[64]byte]int)
+		for {
+			obj, err := q.Consume()
+			if err != nil {
+				done <- seen
+				return
+			}
+			buf := obj.(*[64]byte)
+			seen[buf]++
+			q.Recycle(buf)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		obj, err := q.Acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj.(*[64]byte)[0] = byte(i)
+		q.Publish(obj)
+	}
+	// Once every object is back, the consumer is done.
+	held := make([]interface{}, n)
+	for i := range held {
+		held[i], _ = q.Acquire()
+	}
+	q.Dispose()
+
+	seen := <-done
+	var total int
+	for buf, count := range seen {
+		found := false
+		for _, m := range made {
+			found = found || m == buf
+		}
+		if !found {
+			t.Fatalf("consumed an object the factory didn't make")
+		}
+		total += count
+	}
+	if total != 1000 {
+		t.Fatalf("expected 1000 objects consumed, got %d", total)
+	}
+}
+
+func TestDoubleBufferedQueueAllocs(t *testing.T) {
+	q := NewDoubleBufferedQueue(4, func() interface{} { return new([64]byte) })
+	allocs := testing.AllocsPerRun(1000, func() {
+		obj, _ := q.Acquire()
+		q.Publish(obj)
+		obj, _ = q.Consume()
+		q.Recycle(obj)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations in steady state, got %v", allocs)
+	}
+}
+
+func TestDrainToChan(t *testing.T) {
+	const count = 1000
+	q := NewRingBuffer(64)
+	out := make(chan []interface{}, 4)
+	go q.DrainToChan(out, 16)
+
+	go func() {
+		for i := 0; i < count; i++ {
+			q.Put(i)
+		}
+	}()
+
+	var next int
+	for next < count {
+		batch := <-out
+		if len(batch) == 0 || len(batch) > 16 {
+			t.Fatalf("expected 1 to 16 items per batch, got %d", len(batch))
+		}
+		for _, item := range batch {
+			if item != next {
+				t.Fatalf("expected %d, got %v", next, item)
+			}
+			next++
+		}
+	}
+	q.Dispose()
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatal("expected no more batches")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected out to be closed once the queue is disposed")
+	}
+}
+
+func TestGetAvailable(t *testing.T) {
+	q := NewRingBuffer(8)
+	buf := make([]interface{}, 5)
+
+	if _, err := q.GetAvailable(buf, time.Millisecond); err == nil {
+		t.Fatal("expected GetAvailable on an empty queue to time out")
+	}
+
+	// Bursts of 7 wrap around the ring and are taken 5 then 2 at a time.
+	var next, want int
+	for burst := 0; burst < 10; burst++ {
+		for i := 0; i < 7; i++ {
+			q.Put(next)
+			next++
+		}
+		for _, size := range []int{5, 2} {
+			n, err := q.GetAvailable(buf, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != size {
+				t.Fatalf("burst %d: expected %d items, got %d", burst, size, n)
+			}
+			for _, item := range buf[:n] {
+				if item != want {
+					t.Fatalf("expected %d, got %v", want, item)
+				}
+				want++
+			}
+		}
+	}
+
+	// The first item is waited for, the rest are not.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(next)
+	}()
+	if n, err := q.GetAvailable(buf, time.Second); err != nil || n != 1 || buf[0] != want {
+		t.Fatalf("expected 1 item %d, got %d %v %v", want, n, buf[0], err)
+	}
+
+	q.Dispose()
+	if _, err := q.GetAvailable(buf, 0); err == nil {
+		t.Fatal("expected GetAvailable on a disposed queue to fail")
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	q := NewRingBuffer(8)
+	buf := make([]interface{}, 5)
+
+	// Bursts of 7 wrap around the ring and are taken 5 then 2 at a time.
+	var next, want int
+	for burst := 0; burst < 10; burst++ {
+		for i := 0; i < 7; i++ {
+			q.Put(next)
+			next++
+		}
+		for _, size := range []int{5, 2} {
+			n, err := q.GetBatch(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != size {
+				t.Fatalf("burst %d: expected %d items, got %d", burst, size, n)
+			}
+			for _, item := range buf[:n] {
+				if item != want {
+					t.Fatalf("expected %d, got %v", want, item)
+				}
+				want++
+			}
+		}
+	}
+
+	// An empty queue blocks until there is an item.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Put(next)
+	}()
+	if n, err := q.GetBatch(buf); err != nil || n != 1 || buf[0] != want {
+		t.Fatalf("expected 1 item %d, got %d %v %v", want, n, buf[0], err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Dispose()
+	}()
+	if _, err := q.GetBatch(buf); err == nil {
+		t.Fatal("expected GetBatch to fail once the queue is disposed")
+	}
+}
+
+func TestPutBatch(t *testing.T) {
+	q := NewRingBuffer(8)
+	q.Put(-1)
+	items := make([]interface{}, 10)
+	for i := range items {
+		items[i] = i
+	}
+
+	// Only the free space is filled.
+	n, err := q.PutBatch(items)
+	if err != nil || n != 7 {
+		t.Fatalf("expected 7 items enqueued, got %d, %v", n, err)
+	}
+	if n, err := q.PutBatch(items[n:]); err != nil || n != 0 {
+		t.Fatalf("expected a full queue to take nothing, got %d, %v", n, err)
+	}
+	for want := -1; want < 7; want++ {
+		if item, err := q.Get(); err != nil || item != want {
+			t.Fatalf("expected %d, got %v, %v", want, item, err)
+		}
+	}
+
+	// The rest goes in once there is room, wrapping around the ring.
+	if n, err := q.PutBatch(items[7:]); err != nil || n != 3 {
+		t.Fatalf("expected 3 items enqueued, got %d, %v", n, err)
+	}
+	buf := make([]interface{}, 8)
+	if n, err := q.GetBatch(buf); err != nil || fmt.Sprint(buf[:n]) != "[7 8 9]" {
+		t.Fatalf("expected [7 8 9], got %v, %v", buf[:n], err)
+	}
+
+	q = NewRingBuffer(8, WithValidator(func(item interface{}) error {
+		if item == 2 {
+			return errors.New("invalid")
+		}
+		return nil
+	}))
+	if n, err := q.PutBatch(items); err == nil || n != 2 {
+		t.Fatalf("expected the items before the invalid one enqueued, got %d, %v", n, err)
+	}
+	if n, err := q.GetBatch(buf); err != nil || fmt.Sprint(buf[:n]) != "[0 1]" {
+		t.Fatalf("expected [0 1], got %v, %v", buf[:n], err)
+	}
+
+	q.Dispose()
+	if _, err := q.PutBatch(items); err == nil {
+		t.Fatal("expected PutBatch on a disposed queue to fail")
+	}
+}
+
+func TestLen(t *testing.T) {
+	q := NewRingBuffer(4)
+	if l := q.Len(); l != 0 || !q.IsEmpty() || q.IsFull() {
+		t.Fatalf("expected an empty queue, got length %d", l)
+	}
+	for i := 0; i < 4; i++ {
+		q.Put(i)
+	}
+	if l := q.Len(); l != 4 || q.IsEmpty() || !q.IsFull() {
+		t.Fatalf("expected a full queue, got length %d", l)
+	}
+	q.Get()
+	if l := q.Len(); l != 3 || q.IsEmpty() || q.IsFull() {
+		t.Fatalf("expected length 3, got %d", l)
+	}
+}
+
+// TestWraparound runs items through the queue across the point where its
+// uint64 sequences wrap around to 0.
+func TestWraparound(t *testing.T) {
+	ops := 1 << 20
+	if testing.Short() {
+		ops = 1 << 14
+	}
+	q := NewRingBuffer(64)
+	q.fastForward(math.MaxUint64 - uint64(ops)/2)
+
+	go func() {
+		for i := 0; i < ops; i++ {
+			q.Put(i)
+		}
+	}()
+	for i := 0; i < ops; i++ {
+		got, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %v", i, got)
+		}
+	}
+	if q.read >= uint64(ops) {
+		t.Fatalf("expected the read sequence to have wrapped around, got %d", q.read)
+	}
+}
+
+func TestWraparoundCancelAndAck(t *testing.T) {
+	q := NewRingBuffer(4)
+	q.fastForward(math.MaxUint64 - 8)
+
+	for i := 0; i < 16; i++ {
+		seq, err := q.PutTracked(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel, _ := q.PutCancelable(-1)
+		cancel()
+		got, gotSeq, _ := q.GetIndexed()
+		if got != i || gotSeq != seq {
+			t.Fatalf("expected %d at %d, got %v at %d", i, seq, got, gotSeq)
+		}
+		q.Ack(gotSeq)
+		if err := q.WaitAck(seq, time.Second); err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+	}
+}
+
+func TestDisposeError(t *testing.T) {
+	errStop := errors.New("stopped")
+	q := NewRingBuffer(4, WithDisposeError(errStop))
+	q.Dispose()
+	if _, err := q.Get(); !errors.Is(err, errStop) {
+		t.Fatalf("expected Get to return %v, got %v", errStop, err)
+	}
+	if _, err := q.Poll(time.Millisecond); !errors.Is(err, errStop) {
+		t.Fatalf("expected Poll to return %v, got %v", errStop, err)
+	}
+	if err := q.Put(1); !errors.Is(err, errStop) {
+		t.Fatalf("expected Put to return %v, got %v", errStop, err)
+	}
+
+	q = NewRingBuffer(4, WithDisposeError(io.EOF))
+	q.Dispose()
+	if _, err := q.Get(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestFullPolicy(t *testing.T) {
+	fill := func(policy FullPolicy) *RingBuffer {
+		q := NewRingBuffer(4, WithFullPolicy(policy))
+		for i := 0; i < 4; i++ {
+			q.Put(i)
+		}
+		return q
+	}
+	drain := func(q *RingBuffer) []interface{} {
+		var items []interface{}
+		for {
+			item, err := q.Poll(time.Millisecond)
+			if err != nil {
+				return items
+			}
+			items = append(items, item)
+		}
+	}
+
+	q := fill(DropNewest)
+	if err := q.Put(4); err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(q); fmt.Sprint(got) != "[0 1 2 3]" || q.Dropped() != 1 {
+		t.Fatalf("DropNewest: expected [0 1 2 3] and 1 drop, got %v and %d", got, q.Dropped())
+	}
+
+	q = fill(DropOldest)
+	q.Put(4)
+	q.Put(5)
+	if got := drain(q); fmt.Sprint(got) != "[2 3 4 5]" || q.Dropped() != 2 {
+		t.Fatalf("DropOldest: expected [2 3 4 5] and 2 drops, got %v and %d", got, q.Dropped())
+	}
+
+	q = fill(Error)
+	if err := q.Put(4); err != ErrFull {
+		t.Fatalf("Error: expected ErrFull, got %v", err)
+	}
+	if got := drain(q); fmt.Sprint(got) != "[0 1 2 3]" || q.Dropped() != 0 {
+		t.Fatalf("Error: expected [0 1 2 3] and no drops, got %v and %d", got, q.Dropped())
+	}
+
+	q = fill(Block)
+	if ok, _ := q.Offer(4); ok {
+		t.Fatal("Block: expected offer on a full queue to fail")
+	}
+}
+
+// TestDropNewestWrappers checks that the put wrappers tell an item the
+// DropNewest policy dropped from one that was enqueued or deduped.
+func TestDropNewestWrappers(t *testing.T) {
+	fill := func() *RingBuffer {
+		q := NewRingBuffer(4, WithFullPolicy(DropNewest), WithDedupAdjacent(func(a, b interface{}) bool {
+			return a == b
+		}))
+		for i := 0; i < 4; i++ {
+			q.Put(i)
+		}
+		return q
+	}
+
+	q := fill()
+	if deduped, err := q.PutDeduped(4); deduped || err != ErrFull {
+		t.Fatalf("PutDeduped: expected ErrFull for a dropped item, got %v, %v", deduped, err)
+	}
+
+	q = fill()
+	seq, err := q.PutTracked(4)
+	if err != ErrFull {
+		t.Fatalf("PutTracked: expected ErrFull for a dropped item, got seq %d, %v", seq, err)
+	}
+	q.Get()
+	q.Ack(0)
+	if err := q.WaitAck(3, time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("PutTracked: expected the last enqueued item to be unacknowledged, got %v", err)
+	}
+
+	q = fill()
+	if cancel, err := q.PutCancelable(4); cancel != nil || err != ErrFull {
+		t.Fatalf("PutCancelable: expected ErrFull for a dropped item, got %v", err)
+	}
+	if q.Dropped() != 1 {
+		t.Fatalf("expected 1 drop, got %d", q.Dropped())
+	}
+}
+
+func TestPutOverwrite(t *testing.T) {
+	q := NewRingBuffer(4, WithFullPolicy(DropOldest))
+	for i := 0; i < 7; i++ {
+		if err := q.PutOverwrite(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 3; i < 7; i++ {
+		if got, err := q.Get(); got != i || err != nil {
+			t.Fatalf("expected the latest items, got %v, %v instead of %d", got, err, i)
+		}
+	}
+	if d := q.Dropped(); d != 3 {
+		t.Fatalf("expected 3 items overwritten, got %d", d)
+	}
+
+	if err := NewRingBuffer(4).PutOverwrite(0); err != ErrNotDropOldest {
+		t.Fatalf("expected PutOverwrite to require the DropOldest policy, got %v", err)
+	}
+	q.Dispose()
+	if err := q.PutOverwrite(0); err != ErrDisposed {
+		t.Fatalf("expected ErrDisposed, got %v", err)
+	}
+}
+
+func TestDropOldestConcurrent(t *testing.T) {
+	const count = 100000
+	q := NewRingBuffer(8, WithFullPolicy(DropOldest))
+
+	go func() {
+		for i := 0; i < count; i++ {
+			q.Put(i)
+		}
+	}()
+
+	var received uint64
+	buf := make([]interface{}, 4)
+	last := -1
+	for last != count-1 {
+		n, err := q.GetAvailable(buf, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range buf[:n] {
+			if item.(int) <= last {
+				t.Fatalf("got %v after %d", item, last)
+			}
+			last = item.(int)
+		}
+		received += uint64(n)
+	}
+	if received+q.Dropped() != count {
+		t.Fatalf("expected %d items received or dropped, got %d and %d", count, received, q.Dropped())
+	}
+}
+
+func TestRunConsumerSafe(t *testing.T) {
+	q := NewRingBuffer(16)
+	for i := 0; i < 10; i++ {
+		q.Put(i)
+	}
+
+	var handled, panicked []interface{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.RunConsumerSafe(func(item interface{}) {
+			if item.(int)%3 == 0 {
+				panic(fmt.Sprintf("bad item %v", item))
+			}
+			handled = append(handled, item)
+		}, func(item interface{}, r interface{}) {
+			if r != fmt.Sprintf("bad item %v", item) {
+				t.Errorf("unexpected panic value %v for %v", r, item)
+			}
+			panicked = append(panicked, item)
+		})
+	}()
+
+	// Items left at dispose are still handled.
+	q.Dispose()
+	<-done
+
+	if fmt.Sprint(handled) != "[1 2 4 5 7 8]" {
+		t.Fatalf("expected [1 2 4 5 7 8] handled, got %v", handled)
+	}
+	if fmt.Sprint(panicked) != "[0 3 6 9]" {
+		t.Fatalf("expected [0 3 6 9] to panic, got %v", panicked)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	q := NewRingBuffer(16, WithTTL(10*time.Millisecond))
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	// The consumer stalls, the first items go stale.
+	time.Sleep(20 * time.Millisecond)
+	for i := 5; i < 8; i++ {
+		q.Put(i)
+	}
+
+	for i := 5; i < 8; i++ {
+		if got, err := q.Poll(time.Second); got != i {
+			t.Fatalf("expected %d, got %v, %v", i, got, err)
+		}
+	}
+	if n := q.Expired(); n != 5 {
+		t.Fatalf("expected 5 expired items, got %d", n)
+	}
+	if _, err := q.Poll(time.Millisecond); err == nil {
+		t.Fatal("expected the queue to be empty")
+	}
+}
+
+func TestGetGrouped(t *testing.T) {
+	type order struct {
+		customer string
+		id       int
+	}
+	customer := func(item interface{}) interface{} {
+		return item.(order).customer
+	}
+
+	q := NewRingBuffer(16)
+	id := 0
+	for _, c := range []string{"a", "a", "a", "b", "c", "c", "c", "c", "c", "a"} {
+		q.Put(order{c, id})
+		id++
+	}
+
+	want := []struct {
+		key string
+		ids []int
+	}{
+		{"a", []int{0, 1, 2}},
+		{"b", []int{3}},
+		{"c", []int{4, 5, 6, 7}},
+		{"c", []int{8}},
+		{"a", []int{9}},
+	}
+	for _, w := range want {
+		key, items, err := q.GetGrouped(customer, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != w.key || len(items) != len(w.ids) {
+			t.Fatalf("expected group %s of %v, got %v of %v", w.key, w.ids, key, items)
+		}
+		for i, item := range items {
+			if item.(order).id != w.ids[i] {
+				t.Fatalf("expected group %s of %v, got %v of %v", w.key, w.ids, key, items)
+			}
+		}
+	}
+
+	// Groups span the end of the ring and wait for their first item.
+	done := make(chan []interface{})
+	go func() {
+		_, items, _ := q.GetGrouped(customer, 16)
+		done <- items
+	}()
+	for i := 0; i < 8; i++ {
+		q.Put(order{"d", id})
+		id++
+	}
+	q.Put(order{"e", id})
+	if items := <-done; len(items) == 0 || items[0].(order).id != 10 {
+		t.Fatalf("expected a group starting at order 10, got %v", items)
+	}
+
+	q.Dispose()
+	if _, _, err := q.GetGrouped(customer, 4); err == nil {
+		t.Fatal("expected GetGrouped on a disposed queue to fail")
+	}
+	lossy := NewRingBuffer(4, WithFullPolicy(DropOldest))
+	if _, _, err := lossy.GetGrouped(customer, 4); err != ErrDropOldest {
+		t.Fatalf("expected %v, got %v", ErrDropOldest, err)
+	}
+	q = NewRingBuffer(4, WithFullPolicy(DropOldest))
+	if _, _, err := q.GetGrouped(customer, 4); err == nil {
+		t.Fatal("expected GetGrouped with DropOldest to fail")
+	}
+}
+
+// benchmarkPingPong measures the round trip of an item sent to an otherwise
+// idle consumer and echoed back.
+func benchmarkPingPong(b *testing.B, opts ...Option) {
+	ping := NewRingBuffer(2, opts...)
+	pong := NewRingBuffer(2, opts...)
+	go func() {
+		for {
+			item, err := ping.Get()
+			if err != nil {
+				return
+			}
+			pong.Put(item)
+		}
+	}()
+	defer ping.Dispose()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ping.Put(i)
+		pong.Get()
+	}
+}
+
+func BenchmarkPingPongGosched(b *testing.B) {
+	benchmarkPingPong(b)
+}
+
+func BenchmarkPingPongPureSpin(b *testing.B) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		b.Skip("pure spin needs a core per goroutine")
+	}
+	benchmarkPingPong(b, WithPureSpin())
+}
+
+func BenchmarkPingPongCPUPause(b *testing.B) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		b.Skip("pure spin needs a core per goroutine")
+	}
+	benchmarkPingPong(b, WithCPUPause())
+}
+
+func TestPureSpin(t *testing.T) {
+	for _, opt := range []Option{WithPureSpin(), WithCPUPause()} {
+		q := NewRingBuffer(2, opt)
+		for i := 0; i < 5; i++ {
+			q.Put(i)
+			if item, err := q.Get(); item != i || err != nil {
+				t.Fatalf("expected %d, got %v, %v", i, item, err)
+			}
+		}
+		if _, err := q.Poll(time.Millisecond); err == nil {
+			t.Fatal("expected poll on an empty queue to time out")
+		}
+	}
+}
+
+func TestWeightedDrainer(t *testing.T) {
+	d := NewWeightedDrainer()
+	weights := []int{4, 1, 2}
+	queues := make([]*RingBuffer, len(weights))
+	for i, w := range weights {
+		queues[i] = NewRingBuffer(1024)
+		if idx := d.Add(queues[i], w); idx != i {
+			t.Fatalf("expected index %d, got %d", i, idx)
+		}
+		for j := 0; j < 1000; j++ {
+			queues[i].Put(j)
+		}
+	}
+
+	// While every queue is busy, deliveries follow the weights.
+	counts := make([]int, len(weights))
+	next := make([]int, len(weights))
+	for i := 0; i < 700; i++ {
+		item, idx, err := d.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item != next[idx] {
+			t.Fatalf("expected %d from queue %d, got %v", next[idx], idx, item)
+		}
+		next[idx]++
+		counts[idx]++
+	}
+	for i, w := range weights {
+		if counts[i] != 100*w {
+			t.Fatalf("expected deliveries %v in the ratio of %v", counts, weights)
+		}
+	}
+
+	// Empty and disposed queues are skipped.
+	queues[0].Dispose()
+	for i := 0; i < 1000-next[1]; i++ {
+		if _, idx, err := d.Next(); idx == 0 || err != nil {
+			t.Fatalf("expected an item from queue 1 or 2, got queue %d, %v", idx, err)
+		}
+	}
+	queues[1].Dispose()
+	queues[2].Dispose()
+	if _, _, err := d.Next(); err == nil {
+		t.Fatal("expected Next to fail once every queue is disposed")
+	}
+}
+
+func TestRunConsumerRetry(t *testing.T) {
+	for _, disposeFirst := range []bool{false, true} {
+		q := NewRingBuffer(8)
+		for i := 0; i < 5; i++ {
+			q.Put(i)
+		}
+		if disposeFirst {
+			q.Dispose()
+		}
+
+		// 1 always fails, 3 fails once.
+		var calls []interface{}
+		failed := map[interface{}]bool{}
+		dead := make(chan interface{}, 1)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			q.RunConsumerRetry(func(item interface{}) error {
+				calls = append(calls, item)
+				if item == 1 || item == 3 && !failed[item] {
+					failed[item] = true
+					return fmt.Errorf("failed %v", item)
+				}
+				return nil
+			}, 3, func(item interface{}, err error) {
+				if err.Error() != fmt.Sprintf("failed %v", item) {
+					t.Errorf("unexpected error %v for %v", err, item)
+				}
+				dead <- item
+			})
+		}()
+
+		if item := <-dead; item != 1 {
+			t.Fatalf("expected 1 to be dead-lettered, got %v", item)
+		}
+		q.Dispose()
+		<-done
+		if fmt.Sprint(calls) != "[0 1 2 3 4 1 3 1]" {
+			t.Fatalf("expected retries after the queued items, got %v", calls)
+		}
+	}
+}
+
+func TestWaitForSeq(t *testing.T) {
+	q := NewRingBuffer(4)
+	go func() {
+		for i := 0; i < 10; i++ {
+			q.Put(i)
+		}
+	}()
+
+	// The consumer waits for the producer to reach its checkpoint, and the
+	// producer for the consumer to catch up.
+	if err := q.WaitForWriteSeq(3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	caught := make(chan error)
+	go func() {
+		caught <- q.WaitForReadSeq(6, time.Second)
+	}()
+	for i := 0; i < 6; i++ {
+		if item, _ := q.Get(); item != i {
+			t.Fatalf("expected %d, got %v", i, item)
+		}
+	}
+	if err := <-caught; err != nil {
+		t.Fatal(err)
+	}
+	for i := 6; i < 10; i++ {
+		q.Get()
+	}
+	if err := q.WaitForWriteSeq(10, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.WaitForWriteSeq(11, time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected waiting for a write that never happens to time out, got %v", err)
+	}
+	if err := q.WaitForReadSeq(11, time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected waiting for a read that never happens to time out, got %v", err)
+	}
+	go q.Dispose()
+	if err := q.WaitForReadSeq(11, 0); err == nil {
+		t.Fatal("expected waiting on a disposed queue to fail")
+	}
+}
+
+func TestConservation(t *testing.T) {
+	q := NewRingBuffer(512)
+	queuetest.Conservation(t, q, q.counters)
+}
+
+func TestCodec(t *testing.T) {
+	type trade struct {
+		Symbol string
+		Price  float64
+		Qty    int
+	}
+	encode := func(item interface{}) ([]byte, error) {
+		return json.Marshal(item)
+	}
+	decode := func(b []byte) (interface{}, error) {
+		var tr trade
+		err := json.Unmarshal(b, &tr)
+		return tr, err
+	}
+	q := NewRingBuffer(4, WithCodec(encode, decode))
+
+	in := trade{"ACME", 12.5, 100}
+	if err := q.Put(&in); err != nil {
+		t.Fatal(err)
+	}
+	// The queue holds the encoding, not the producer's value.
+	in.Qty = 0
+	if data := q.nodes[0].data; fmt.Sprint(data) != fmt.Sprint([]byte(`{"Symbol":"ACME","Price":12.5,"Qty":100}`)) {
+		t.Fatalf("expected the item to be stored encoded, got %v", data)
+	}
+	item, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := item.(trade); out != (trade{"ACME", 12.5, 100}) {
+		t.Fatalf("expected the trade to round-trip, got %+v", out)
+	}
+
+	if err := q.Put(func() {}); err == nil {
+		t.Fatal("expected an item failing to encode to be rejected")
+	}
+	q.Put([]int{1})
+	q.Put(trade{"XYZ", 1, 2})
+	if _, err := q.Get(); err == nil {
+		t.Fatal("expected an item failing to decode to return an error")
+	}
+	if item, err := q.Get(); err != nil || item.(trade).Symbol != "XYZ" {
+		t.Fatalf("expected the next item to decode, got %v, %v", item, err)
+	}
+
+	// A batch stops at an item failing to decode.
+	q.Put(trade{"A", 1, 1})
+	q.Put([]int{1})
+	q.Put(trade{"B", 1, 1})
+	buf := make([]interface{}, 4)
+	if n, err := q.GetAvailable(buf, time.Second); n != 1 || err == nil || buf[0].(trade).Symbol != "A" {
+		t.Fatalf("expected A and a decode error, got %v, %v", buf[:n], err)
+	}
+	if n, err := q.GetAvailable(buf, time.Second); n != 1 || err != nil || buf[0].(trade).Symbol != "B" {
+		t.Fatalf("expected B, got %v, %v", buf[:n], err)
+	}
+}
+
+func TestDrainAppend(t *testing.T) {
+	q := NewRingBuffer(8)
+	if items := q.Drain(); items != nil {
+		t.Fatalf("expected nothing to drain, got %v", items)
+	}
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	if items := q.Drain(); fmt.Sprint(items) != "[0 1 2 3 4]" {
+		t.Fatalf("expected [0 1 2 3 4], got %v", items)
+	}
+
+	buf := []interface{}{"kept"}
+	for i := 5; i < 8; i++ {
+		q.Put(i)
+	}
+	buf = q.DrainAppend(buf)
+	if fmt.Sprint(buf) != "[kept 5 6 7]" {
+		t.Fatalf("expected the items appended to [kept], got %v", buf)
+	}
+
+	for i := 8; i < 13; i++ {
+		q.Put(i)
+	}
+	buf, err := q.GetBatchAppend(buf[:1], 3)
+	if err != nil || fmt.Sprint(buf) != "[kept 8 9 10]" {
+		t.Fatalf("expected 3 items appended to [kept], got %v, %v", buf, err)
+	}
+	buf, _ = q.GetBatchAppend(buf[:0], 3)
+	if fmt.Sprint(buf) != "[11 12]" {
+		t.Fatalf("expected the 2 items left, got %v", buf)
+	}
+
+	// Reusing a large enough buffer doesn't allocate.
+	buf = make([]interface{}, 0, 8)
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := 0; i < 4; i++ {
+			q.Put(i)
+		}
+		buf = q.DrainAppend(buf[:0])
+		for i := 0; i < 4; i++ {
+			q.Put(i)
+		}
+		buf, _ = q.GetBatchAppend(buf[:0], 8)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocation reusing the buffer, got %v", allocs)
+	}
+
+	q.Dispose()
+	if _, err := q.GetBatchAppend(buf[:0], 8); err == nil {
+		t.Fatal("expected GetBatchAppend on a disposed queue to fail")
+	}
+}
+
+func BenchmarkDrainAppend(b *testing.B) {
+	q := NewRingBuffer(64)
+	buf := make([]interface{}, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 32; j++ {
+			q.Put(nil)
+		}
+		buf = q.DrainAppend(buf[:0])
+	}
+}
+
+func BenchmarkGetBatch(b *testing.B) {
+	q := NewRingBuffer(64)
+	buf := make([]interface{}, 32)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 32; j++ {
+			q.Put(nil)
+		}
+		q.GetBatch(buf)
+	}
+}
+
+func BenchmarkPutBatch(b *testing.B) {
+	q := NewRingBuffer(64)
+	items := make([]interface{}, 32)
+	buf := make([]interface{}, 32)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		q.PutBatch(items)
+		q.GetBatch(buf)
+	}
+}
+
+func BenchmarkDrain(b *testing.B) {
+	q := NewRingBuffer(64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 32; j++ {
+			q.Put(nil)
+		}
+		q.Drain()
+	}
+}
+
+func TestRequestedCap(t *testing.T) {
+	q := NewRingBuffer(1000)
+	if c := q.RequestedCap(); c != 1000 {
+		t.Fatalf("expected a requested capacity of 1000, got %d", c)
+	}
+	if c := q.Cap(); c != 1024 {
+		t.Fatalf("expected a capacity of 1024, got %d", c)
+	}
+}
+
+func TestDrainToChanAfterDispose(t *testing.T) {
+	q := NewRingBuffer(64)
+	for i := 0; i < 40; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Dispose()
+
+	out := make(chan []interface{}, 4)
+	go q.DrainToChan(out, 16)
+	var next int
+	for batch := range out {
+		if len(batch) == 0 || len(batch) > 16 {
+			t.Fatalf("expected 1 to 16 items per batch, got %d", len(batch))
+		}
+		for _, item := range batch {
+			if item != next {
+				t.Fatalf("expected %d, got %v", next, item)
+			}
+			next++
+		}
+	}
+	if next != 40 {
+		t.Fatalf("expected the 40 items left at dispose, got %d", next)
+	}
+}
+
+func TestDrainToChanBatchMax(t *testing.T) {
+	for _, batchMax := range []int{0, -1} {
+		q := NewRingBuffer(8)
+		for i := 0; i < 3; i++ {
+			q.Put(i)
+		}
+		q.Dispose()
+
+		out := make(chan []interface{}, 4)
+		go q.DrainToChan(out, batchMax)
+		var next int
+		for batch := range out {
+			if len(batch) != 1 {
+				t.Fatalf("batchMax %d: expected single item batches, got %v", batchMax, batch)
+			}
+			if batch[0] != next {
+				t.Fatalf("batchMax %d: expected %d, got %v", batchMax, next, batch[0])
+			}
+			next++
+		}
+		if next != 3 {
+			t.Fatalf("batchMax %d: expected 3 items, got %d", batchMax, next)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package spsc
+
+import (
+	"runtime"
+)
+
+// WeightedDrainer is the consumer of several queues, which it drains in
+// weighted round-robin: it takes up to weight items in a row from a queue
+// before moving on to the next, so that over time each busy queue gets a
+// share of the deliveries proportional to its weight.  An empty queue gives
+// up its turn, and a disposed one is skipped for good.
+type WeightedDrainer struct {
+	queues  []*RingBuffer
+	weights []int
+	cur     int // Queue whose turn it is.
+	credit  int // Items left in cur's turn.
+	buf     [1]interface{}
+}
+
+// NewWeightedDrainer returns a WeightedDrainer without any queue.
+func NewWeightedDrainer() *WeightedDrainer {
+	return &WeightedDrainer{}
+}
+
+// Add makes d drain q, taking up to weight items in a row from it, at least
+// 1, and returns the index of q that Next reports its items with.  d becomes
+// the single consumer of q.
+func (d *WeightedDrainer) Add(q *RingBuffer, weight int) int {
+	if weight < 1 {
+		weight = 1
+	}
+	d.queues = append(d.queues, q)
+	d.weights = append(d.weights, weight)
+	if len(d.queues) == 1 {
+		d.credit = weight
+	}
+	return len(d.queues) - 1
+}
+
+// Next returns the next item and the index of the queue it came from.  This
+// call will block until one of the queues has an item.  An error will be
+// returned once every queue is disposed, or along with the index of the
+// queue an item failed to decode in, see WithCodec.
+func (d *WeightedDrainer) Next() (interface{}, int, error) {
+	for {
+		disposed := 0
+		for range d.queues {
+			q := d.queues[d.cur]
+			if q.IsDisposed() {
+				disposed++
+			} else if d.credit > 0 {
+				if q.scrub != nil {
+					q.release()
+				}
+				items, err := q.takeReady(d.buf[:0], 1, nil)
+				if len(items) > 0 || err != nil {
+					var item interface{}
+					if len(items) > 0 {
+						item = items[0]
+					}
+					d.buf[0] = nil
+					d.credit--
+					return item, d.cur, err
+				}
+			}
+			d.cur = (d.cur + 1) % len(d.queues)
+			d.credit = d.weights[d.cur]
+		}
+		if len(d.queues) == 0 || disposed == len(d.queues) {
+			return nil, 0, d.disposedErr()
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+}
+
+// disposedErr is the error of the last queue, as they are all disposed.
+func (d *WeightedDrainer) disposedErr() error {
+	if len(d.queues) == 0 {
+		return ErrDisposed
+	}
+	return d.queues[len(d.queues)-1].disposedErr()
+}
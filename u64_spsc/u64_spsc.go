@@ -0,0 +1,219 @@
+package u64_spsc
+
+import (
+	"errors"
+	"lockfree/queue"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Errors of package queue.
+var (
+	ErrDisposed = queue.ErrDisposed
+	ErrTimeout  = queue.ErrTimeout
+)
+
+// Layout of a ring buffer in memory, in bytes.  Each shared header word gets
+// its own cache line, and the slots follow the header.
+const (
+	writeOffset    = 0
+	readOffset     = 64
+	disposedOffset = 128
+	sizeOffset     = 136
+	// HeaderSize is the number of bytes taken by the header.
+	HeaderSize = 192
+	slotSize   = 8
+)
+
+// roundUp takes a uint64 greater than 0 and rounds it up to the next
+// power of 2.
+func roundUp(v uint64) uint64 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+// RingBuffer is a SPSC lockfree queue of uint64 values.  All of its state
+// lives in a single block of memory laid out as
+//
+//	[0, 8)                      write cursor, owned by producer
+//	[64, 72)                    read cursor, owned by consumer
+//	[128, 136)                  disposed flag
+//	[136, 144)                  capacity
+//	[192, 192+8*capacity)       slots
+//
+// so the block can be shared, e.g. through mmap, by a producer and a
+// consumer living in different processes.  Values are plain uint64, as
+// pointers and interfaces are meaningless across processes.
+type RingBuffer struct {
+	write    *uint64
+	read     *uint64
+	disposed *uint64
+	mask     uint64
+	slots    []uint64
+}
+
+// MemSize returns the number of bytes needed to hold a ring buffer with the
+// specified size.
+func MemSize(size uint64) int {
+	return HeaderSize + int(roundUp(size))*slotSize
+}
+
+// NewRingBuffer will allocate, initialize, and return a ring buffer
+// with the specified size.
+func NewRingBuffer(size uint64) *RingBuffer {
+	words := make([]uint64, MemSize(size)/slotSize)
+	mem := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*slotSize)
+	rb, _ := NewRingBufferInPlace(mem, size)
+	return rb
+}
+
+// NewRingBufferInPlace initializes a ring buffer with the specified size in
+// mem and returns it.  mem must be 8 byte aligned, preferably 64 byte
+// aligned to keep the cursors on separate cache lines, and at least
+// MemSize(size) long.  Any previous content of mem is discarded.
+func NewRingBufferInPlace(mem []byte, size uint64) (*RingBuffer, error) {
+	size = roundUp(size)
+	if len(mem) < MemSize(size) {
+		return nil, errors.New(`queue: memory too small`)
+	}
+	if uintptr(unsafe.Pointer(&mem[0]))%slotSize != 0 {
+		return nil, errors.New(`queue: memory not 8 byte aligned`)
+	}
+	*word(mem, writeOffset) = 0
+	*word(mem, readOffset) = 0
+	*word(mem, disposedOffset) = 0
+	atomic.StoreUint64(word(mem, sizeOffset), size)
+	return attach(mem, size), nil
+}
+
+// AttachRingBuffer returns the ring buffer previously initialized in mem by
+// NewRingBufferInPlace, possibly by another process.
+func AttachRingBuffer(mem []byte) (*RingBuffer, error) {
+	if len(mem) < HeaderSize {
+		return nil, errors.New(`queue: memory too small`)
+	}
+	if uintptr(unsafe.Pointer(&mem[0]))%slotSize != 0 {
+		return nil, errors.New(`queue: memory not 8 byte aligned`)
+	}
+	size := atomic.LoadUint64(word(mem, sizeOffset))
+	if size == 0 || size != roundUp(size) || len(mem) < MemSize(size) {
+		return nil, errors.New(`queue: memory holds no ring buffer`)
+	}
+	return attach(mem, size), nil
+}
+
+func word(mem []byte, offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&mem[offset]))
+}
+
+func attach(mem []byte, size uint64) *RingBuffer {
+	return &RingBuffer{
+		write:    word(mem, writeOffset),
+		read:     word(mem, readOffset),
+		disposed: word(mem, disposedOffset),
+		mask:     size - 1,
+		slots:    unsafe.Slice(word(mem, HeaderSize), size),
+	}
+}
+
+// Dispose will dispose of this queue and free any blocked threads
+// in the Put and/or Get methods.  Calling those methods on a disposed
+// queue will return an error.
+func (rb *RingBuffer) Dispose() {
+	atomic.CompareAndSwapUint64(rb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this queue has been
+// disposed.
+func (rb *RingBuffer) IsDisposed() bool {
+	return atomic.LoadUint64(rb.disposed) == 1
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *RingBuffer) Cap() uint64 {
+	return uint64(len(rb.slots))
+}
+
+// Get will return the next item in the queue.  This call will block
+// if the queue is empty.  This call will unblock when an item is added
+// to the queue or Dispose is called on the queue.  An error will be returned
+// if the queue is disposed.
+func (rb *RingBuffer) Get() (uint64, error) {
+	return rb.Poll(0)
+}
+
+// Poll will return the next item in the queue.  This call will block
+// if the queue is empty.  This call will unblock when an item is added
+// to the queue, Dispose is called on the queue, or the timeout is reached. An
+// error will be returned if the queue is disposed or a timeout occurs. A
+// non-positive timeout will block indefinitely.
+func (rb *RingBuffer) Poll(timeout time.Duration) (uint64, error) {
+	var start time.Time
+	if timeout > 0 {
+		start = time.Now()
+	}
+
+	rd := atomic.LoadUint64(rb.read)
+	for {
+		if atomic.LoadUint64(rb.disposed) > 0 {
+			return 0, ErrDisposed
+		}
+		wr := atomic.LoadUint64(rb.write)
+		// Not emtpy.
+		if rd != wr {
+			break
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return 0, ErrTimeout
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	data := atomic.LoadUint64(&rb.slots[rd&rb.mask])
+	atomic.StoreUint64(rb.read, rd+1) // cache coherence traffic.
+	return data, nil
+}
+
+// Put adds the provided item to the queue.  If the queue is full, this
+// call will block until an item is removed from the queue or Dispose is
+// called on the queue.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer) Put(item uint64) error {
+	_, err := rb.put(item, false)
+	return err
+}
+
+// Offer adds the provided item to the queue if there is space.  If the queue
+// is full, this call will return false.  An error will be returned if the
+// queue is disposed.
+func (rb *RingBuffer) Offer(item uint64) (bool, error) {
+	return rb.put(item, true)
+}
+
+func (rb *RingBuffer) put(item uint64, offer bool) (bool, error) {
+	wr := atomic.LoadUint64(rb.write)
+	for {
+		if atomic.LoadUint64(rb.disposed) > 0 {
+			return false, ErrDisposed
+		}
+		rd := atomic.LoadUint64(rb.read)
+		// Not full.
+		if wr-rd < rb.Cap() {
+			break
+		}
+		if offer {
+			return false, nil
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	atomic.StoreUint64(&rb.slots[wr&rb.mask], item)
+	atomic.StoreUint64(rb.write, wr+1) // cache coherence traffic.
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package u64_spsc
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestInPlace(t *testing.T) {
+	const numItems = 1_000
+	words := make([]uint64, MemSize(16)/8)
+	mem := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*8)
+
+	producer, err := NewRingBufferInPlace(mem, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := AttachRingBuffer(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumer.Cap() != 16 {
+		t.Fatalf("expected capacity 16, got %d", consumer.Cap())
+	}
+
+	go func() {
+		for i := uint64(0); i < numItems; i++ {
+			producer.Put(i)
+		}
+	}()
+	for i := uint64(0); i < numItems; i++ {
+		got, err := consumer.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i {
+			t.Fatalf("expected %d, got %d", i, got)
+		}
+	}
+
+	// The header lives in mem at its documented offsets.
+	if words[writeOffset/8] != numItems || words[readOffset/8] != numItems {
+		t.Fatalf("unexpected cursors in memory: %v", words[:HeaderSize/8])
+	}
+	producer.Dispose()
+	if !consumer.IsDisposed() {
+		t.Fatal("expected dispose to be visible through shared memory")
+	}
+}
+
+func TestInPlaceValidation(t *testing.T) {
+	words := make([]uint64, MemSize(16)/8)
+	mem := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*8)
+
+	if _, err := NewRingBufferInPlace(mem[:len(mem)-1], 16); err == nil {
+		t.Fatal("expected an error for memory too small")
+	}
+	if _, err := NewRingBufferInPlace(mem[1:], 8); err == nil {
+		t.Fatal("expected an error for misaligned memory")
+	}
+	if _, err := AttachRingBuffer(mem); err == nil {
+		t.Fatal("expected an error attaching uninitialized memory")
+	}
+}
+
+func BenchmarkU64SPSC(b *testing.B) {
+	q := NewRingBuffer(8192)
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Get()
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		q.Put(uint64(i))
+	}
+}
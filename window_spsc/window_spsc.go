@@ -0,0 +1,166 @@
+package window_spsc
+
+import (
+	"errors"
+	"lockfree/queue"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Errors of package queue.
+var ErrDisposed = queue.ErrDisposed
+
+// ErrWindowSize is returned by GetWindow for a window size that is not
+// positive, which would make every window empty.
+var ErrWindowSize = errors.New(`queue: window size must be positive`)
+
+// roundUp takes a uint64 greater than 0 and rounds it up to the next
+// power of 2.
+func roundUp(v uint64) uint64 {
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+// Event is a timestamped value.
+type Event struct {
+	Time  time.Time
+	Value interface{}
+}
+
+type node struct {
+	event Event
+}
+
+type nodes []node
+
+// RingBuffer is a SPSC lockfree queue of events that the consumer reads in
+// fixed time windows.  The producer must enqueue events in non-decreasing
+// timestamp order; windows are computed from the timestamps alone, so out
+// of order events end up in whatever window is being read when they reach
+// the head of the queue.
+type RingBuffer struct {
+	_        [8]uint64
+	write    uint64 // Shared, owned by producer.
+	_        [8]uint64
+	read     uint64 // Shared, owned by consumer.
+	_        [8]uint64
+	mask     uint64
+	disposed uint64
+	_        [8]uint64
+	nodes    nodes
+}
+
+func (rb *RingBuffer) init(size uint64) {
+	size = roundUp(size)
+	rb.nodes = make(nodes, size)
+	rb.mask = size - 1 // so we don't have to do this with every put/get operation
+}
+
+// NewRingBuffer will allocate, initialize, and return a ring buffer
+// with the specified size.
+func NewRingBuffer(size uint64) *RingBuffer {
+	rb := &RingBuffer{}
+	rb.init(size)
+	return rb
+}
+
+// Dispose will dispose of this queue and free any blocked threads
+// in the Put and/or GetWindow methods.  Calling those methods on a disposed
+// queue will return an error.
+func (rb *RingBuffer) Dispose() {
+	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+}
+
+// IsDisposed will return a bool indicating if this queue has been
+// disposed.
+func (rb *RingBuffer) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *RingBuffer) Cap() uint64 {
+	return uint64(len(rb.nodes))
+}
+
+// GetWindow returns the events of the next time window.  Windows are
+// windowSize long and aligned to multiples of windowSize since the zero
+// time, and the next window is the one holding the oldest buffered event.
+// This call will block if the queue is empty, then returns the start of
+// the window and every buffered event up to the window's end.  Events of
+// that window enqueued later are returned by the next call, so a window
+// may be split across calls when the consumer catches up with the
+// producer.  An error will be returned if the queue is disposed, and
+// ErrWindowSize if windowSize is not positive.
+func (rb *RingBuffer) GetWindow(windowSize time.Duration) (time.Time, []Event, error) {
+	if windowSize <= 0 {
+		return time.Time{}, nil, ErrWindowSize
+	}
+	rd := atomic.LoadUint64(&rb.read)
+	wr := atomic.LoadUint64(&rb.write)
+	for rd == wr {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return time.Time{}, nil, ErrDisposed
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+		wr = atomic.LoadUint64(&rb.write)
+	}
+
+	start := rb.nodes[rd&rb.mask].event.Time.Truncate(windowSize)
+	end := start.Add(windowSize)
+	var events []Event
+	for ; rd != wr; rd++ {
+		n := &rb.nodes[rd&rb.mask]
+		if !n.event.Time.Before(end) {
+			break
+		}
+		events = append(events, n.event)
+		n.event = Event{}
+	}
+	atomic.StoreUint64(&rb.read, rd) // cache coherence traffic.
+	return start, events, nil
+}
+
+// Put adds the provided event to the queue.  If the queue is full, this
+// call will block until an event is removed from the queue or Dispose is
+// called on the queue.  An error will be returned if the queue is disposed.
+func (rb *RingBuffer) Put(t time.Time, value interface{}) error {
+	_, err := rb.put(Event{Time: t, Value: value}, false)
+	return err
+}
+
+// Offer adds the provided event to the queue if there is space.  If the
+// queue is full, this call will return false.  An error will be returned if
+// the queue is disposed.
+func (rb *RingBuffer) Offer(t time.Time, value interface{}) (bool, error) {
+	return rb.put(Event{Time: t, Value: value}, true)
+}
+
+func (rb *RingBuffer) put(event Event, offer bool) (bool, error) {
+	wr := atomic.LoadUint64(&rb.write)
+	for {
+		if atomic.LoadUint64(&rb.disposed) > 0 {
+			return false, ErrDisposed
+		}
+		rd := atomic.LoadUint64(&rb.read)
+		// Not full.
+		if wr-rd < rb.Cap() {
+			break
+		}
+		if offer {
+			return false, nil
+		}
+		runtime.Gosched() // free up the cpu before the next iteration
+	}
+	n := &rb.nodes[wr&rb.mask]
+	n.event = event
+	atomic.StoreUint64(&rb.write, wr+1) // cache coherence traffic.
+	return true, nil
+}
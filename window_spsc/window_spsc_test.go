@@ -0,0 +1,77 @@
+package window_spsc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetWindow(t *testing.T) {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := NewRingBuffer(16)
+	// One second windows: [0, 1s) has 3 events, [1s, 2s) none,
+	// [2s, 3s) 2 and [3s, 4s) 1.
+	offsets := []time.Duration{
+		0, 300 * time.Millisecond, 999 * time.Millisecond,
+		2 * time.Second, 2500 * time.Millisecond,
+		3 * time.Second,
+	}
+	for i, off := range offsets {
+		if err := q.Put(base.Add(off), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct {
+		start  time.Duration
+		values []int
+	}{
+		{0, []int{0, 1, 2}},
+		{2 * time.Second, []int{3, 4}},
+		{3 * time.Second, []int{5}},
+	}
+	for _, w := range want {
+		start, events, err := q.GetWindow(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !start.Equal(base.Add(w.start)) {
+			t.Fatalf("expected window at %v, got %v", base.Add(w.start), start)
+		}
+		if len(events) != len(w.values) {
+			t.Fatalf("window %v: expected %d events, got %v", w.start, len(w.values), events)
+		}
+		for i, ev := range events {
+			if ev.Value != w.values[i] {
+				t.Fatalf("window %v: expected %d, got %v", w.start, w.values[i], ev.Value)
+			}
+		}
+	}
+
+	for _, size := range []time.Duration{0, -time.Second} {
+		if _, _, err := q.GetWindow(size); err != ErrWindowSize {
+			t.Fatalf("window size %v: expected %v, got %v", size, ErrWindowSize, err)
+		}
+	}
+
+	q.Dispose()
+	if _, _, err := q.GetWindow(time.Second); err == nil {
+		t.Fatal("expected an error after dispose")
+	}
+}
+
+func BenchmarkWindowSPSC(b *testing.B) {
+	q := NewRingBuffer(8192)
+	now := time.Now()
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; {
+			_, events, _ := q.GetWindow(time.Microsecond)
+			i += len(events)
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		q.Put(now.Add(time.Duration(i)), `a`)
+	}
+}